@@ -0,0 +1,129 @@
+package gonedice
+
+// node 是语法树节点
+// 每个节点记录其在原始表达式中的字节区间 [pos, end)
+type node interface {
+	span() (int, int)
+}
+
+// pos 保存节点在原始表达式中的位置
+type pos struct {
+	start int
+	end   int
+}
+
+func (p pos) span() (int, int) { return p.start, p.end }
+
+// numberNode 整数字面量
+type numberNode struct {
+	pos
+	v int
+}
+
+// stringNode 双引号字符串字面量，如 "x{i}y"
+type stringNode struct {
+	pos
+	s string
+}
+
+// tupleNode 多元组字面量，如 [1,2,3]
+type tupleNode struct {
+	pos
+	elems []node
+}
+
+// tempNode 临时变量引用，如 $t 或 $t2
+type tempNode struct {
+	pos
+	index int
+}
+
+// varNode 变量引用，如 {STR}；在求值时从 ValueTable 中读取
+type varNode struct {
+	pos
+	name string
+}
+
+// groupNode 括号包裹的子表达式
+type groupNode struct {
+	pos
+	x node
+}
+
+// unaryNode 前缀运算，如逻辑非 !x、负号 -x、正号 +x
+type unaryNode struct {
+	pos
+	op string
+	x  node
+}
+
+// binaryNode 二元运算，op 为小写运算符
+type binaryNode struct {
+	pos
+	op    string
+	left  node
+	right node
+}
+
+// diceNode 掷骰运算 NdM；sides 为 nil 时使用默认面数
+// explode 为可选的爆炸修饰，如 3d6!、d6!!、d6!p>4
+// reroll 为可选的重投修饰，如 2d6r1、d20ro<3
+// success 为可选的成功计数，如 10d10cs>=8f1ds10
+type diceNode struct {
+	pos
+	times   node
+	sides   node
+	explode *explodeMod
+	reroll  *rerollMod
+	success *successMod
+}
+
+// comparePoint 比较点，如 >5、<=2、=6；用于爆炸、重投等掷骰修饰
+type comparePoint struct {
+	pos
+	// op 为 ">"、"<"、">="、"<=" 或 "="
+	op    string
+	value node
+}
+
+// explodeMod 爆炸修饰：kind 为 "!"（爆炸）、"!!"（复利）或 "!p"（穿透）
+// cmp 为 nil 时在掷出最大面时触发
+type explodeMod struct {
+	pos
+	kind string
+	cmp  *comparePoint
+}
+
+// chainNode 附加链 a 与压缩链 c；faces 为 nil 时使用 10 面
+type chainNode struct {
+	pos
+	op        string
+	times     node
+	threshold node
+	faces     node
+}
+
+// ternaryNode 三元条件运算 cond ? then : els，仅求值被选中的分支
+type ternaryNode struct {
+	pos
+	cond node
+	then node
+	els  node
+}
+
+// rerollMod 重投修饰：once 为 true 时（ro）只重投一次，否则（r）重投到条件不成立为止
+type rerollMod struct {
+	pos
+	once bool
+	cmp  *comparePoint
+}
+
+// successMod 成功计数：统计满足 target 的骰子个数
+// fail 为可选的失败条件（f1），每颗满足的骰子扣除一次成功
+// double 为可选的双倍成功条件（ds10），满足的成功骰子计两次
+type successMod struct {
+	pos
+	target *comparePoint
+	fail   *comparePoint
+	double *comparePoint
+}
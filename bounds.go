@@ -0,0 +1,560 @@
+package gonedice
+
+// interval 表示整数闭区间 [lo, hi]
+// loOpen/hiOpen 为 true 时表示对应方向没有有限的界（例如可以无限连锁的骰池）
+type interval struct {
+	lo, hi         int
+	loOpen, hiOpen bool
+}
+
+// pointInterval 返回只包含 v 的区间
+func pointInterval(v int) interval {
+	return interval{lo: v, hi: v}
+}
+
+// openInterval 返回两端都无界的区间
+func openInterval() interval {
+	return interval{loOpen: true, hiOpen: true}
+}
+
+// contains 判断区间是否可能包含 v
+func (a interval) contains(v int) bool {
+	return (a.loOpen || a.lo <= v) && (a.hiOpen || v <= a.hi)
+}
+
+// closed 判断区间两端是否都有界
+func (a interval) closed() bool {
+	return !a.loOpen && !a.hiOpen
+}
+
+// clamp 将区间限制在 [lo, hi] 内，超出部分在求值时会报错，因此不计入范围
+func (a interval) clamp(lo, hi int) interval {
+	if a.loOpen || a.lo < lo {
+		a.lo, a.loOpen = lo, false
+	}
+	if a.hiOpen || a.hi > hi {
+		a.hi, a.hiOpen = hi, false
+	}
+	if a.lo > a.hi {
+		a.lo = a.hi
+	}
+	return a
+}
+
+// atLeast 将区间的下界提高到 v，用于只对下界有限制的参数（如保留个数）
+func (a interval) atLeast(v int) interval {
+	if a.loOpen || a.lo < v {
+		a.lo, a.loOpen = v, false
+	}
+	if !a.hiOpen && a.hi < v {
+		a.hi = v
+	}
+	return a
+}
+
+// union 返回同时覆盖 a 与 b 的最小区间
+func (a interval) union(b interval) interval {
+	out := a
+	if b.loOpen || (!a.loOpen && b.lo < a.lo) {
+		out.lo, out.loOpen = b.lo, b.loOpen
+	}
+	if b.hiOpen || (!a.hiOpen && b.hi > a.hi) {
+		out.hi, out.hiOpen = b.hi, b.hiOpen
+	}
+	return out
+}
+
+// addInterval 返回 a+b 的范围
+func addInterval(a, b interval) interval {
+	return interval{lo: a.lo + b.lo, hi: a.hi + b.hi, loOpen: a.loOpen || b.loOpen, hiOpen: a.hiOpen || b.hiOpen}
+}
+
+// negInterval 返回 -a 的范围
+func negInterval(a interval) interval {
+	return interval{lo: -a.hi, hi: -a.lo, loOpen: a.hiOpen, hiOpen: a.loOpen}
+}
+
+// minInterval 返回 min(x, y) 的范围，其中 x 在 a 内、y 在 b 内
+func minInterval(a, b interval) interval {
+	out := interval{lo: min(a.lo, b.lo), loOpen: a.loOpen || b.loOpen}
+	switch {
+	case a.hiOpen && b.hiOpen:
+		out.hiOpen = true
+	case a.hiOpen:
+		out.hi = b.hi
+	case b.hiOpen:
+		out.hi = a.hi
+	default:
+		out.hi = min(a.hi, b.hi)
+	}
+	return out
+}
+
+// maxInterval 返回 max(x, y) 的范围，其中 x 在 a 内、y 在 b 内
+func maxInterval(a, b interval) interval {
+	return negInterval(minInterval(negInterval(a), negInterval(b)))
+}
+
+// sumInterval 返回 count 个取值均在 elem 内的元素之和的范围，count 不小于 0
+func sumInterval(count, elem interval) interval {
+	out := interval{}
+	// 下界：元素可能为负时取最多的元素，否则取最少的元素
+	if elem.loOpen {
+		out.loOpen = count.hiOpen || count.hi > 0
+	} else if elem.lo < 0 {
+		out.lo, out.loOpen = count.hi*elem.lo, count.hiOpen
+	} else {
+		out.lo = count.lo * elem.lo
+	}
+	// 上界：元素可能为正时取最多的元素，否则取最少的元素
+	if elem.hiOpen {
+		out.hiOpen = count.hiOpen || count.hi > 0
+	} else if elem.hi > 0 {
+		out.hi, out.hiOpen = count.hi*elem.hi, count.hiOpen
+	} else {
+		out.hi = count.lo * elem.hi
+	}
+	return out
+}
+
+// maxBruteForce 两个操作数取值组合数不超过该值时逐一枚举以得到精确范围
+const maxBruteForce = 1 << 12
+
+// scalarInterval 返回标量二元运算结果的范围；导致求值错误的取值组合不计入范围
+func scalarInterval(op string, a, b interval) interval {
+	switch op {
+	case "+":
+		return addInterval(a, b)
+	case "-":
+		return addInterval(a, negInterval(b))
+	}
+
+	if a.closed() && b.closed() && (a.hi-a.lo+1)*(b.hi-b.lo+1) <= maxBruteForce {
+		found := false
+		out := interval{}
+		for x := a.lo; x <= a.hi; x++ {
+			for y := b.lo; y <= b.hi; y++ {
+				v, err := scalarOp(op, x, y)
+				if err != "" {
+					continue
+				}
+				if !found {
+					out = pointInterval(v)
+					found = true
+					continue
+				}
+				out = out.union(pointInterval(v))
+			}
+		}
+		if found {
+			return out
+		}
+		return pointInterval(0)
+	}
+
+	switch op {
+	case "<", ">", "<=", ">=", "==", "!=", "&&", "||":
+		return interval{lo: 0, hi: 1}
+	case "*":
+		if a.closed() && b.closed() {
+			return cornerInterval(op, []int{a.lo, a.hi}, []int{b.lo, b.hi})
+		}
+	case "/":
+		if a.closed() && b.closed() {
+			ys := []int{}
+			for _, y := range []int{b.lo, b.hi, -1, 1} {
+				if y != 0 && b.contains(y) {
+					ys = append(ys, y)
+				}
+			}
+			if len(ys) == 0 {
+				return pointInterval(0)
+			}
+			return cornerInterval(op, []int{a.lo, a.hi}, ys)
+		}
+	case "&":
+		if !a.loOpen && !b.loOpen && a.lo >= 0 && b.lo >= 0 {
+			out := interval{lo: 0, hi: a.hi, hiOpen: a.hiOpen}
+			if !b.hiOpen && (out.hiOpen || b.hi < out.hi) {
+				out.hi, out.hiOpen = b.hi, false
+			}
+			return out
+		}
+	case "|":
+		if a.closed() && b.closed() && a.lo >= 0 && b.lo >= 0 {
+			hi := 1
+			for hi <= a.hi || hi <= b.hi {
+				hi <<= 1
+			}
+			lo := a.lo
+			if b.lo > lo {
+				lo = b.lo
+			}
+			return interval{lo: lo, hi: hi - 1}
+		}
+	}
+	return openInterval()
+}
+
+// cornerInterval 在给定的候选取值上计算运算结果的最小与最大值
+func cornerInterval(op string, xs, ys []int) interval {
+	out := interval{}
+	found := false
+	for _, x := range xs {
+		for _, y := range ys {
+			v, err := scalarOp(op, x, y)
+			if err != "" {
+				continue
+			}
+			if !found {
+				out = pointInterval(v)
+				found = true
+				continue
+			}
+			out = out.union(pointInterval(v))
+		}
+	}
+	return out
+}
+
+// shape 描述一个 Value 可能的取值范围
+type shape struct {
+	// total 数值 V 的范围
+	total interval
+	// elem 每个元数据元素的范围
+	elem interval
+	// count 元数据元素个数的范围
+	count interval
+	// meta 为 true 表示携带整数元数据，作为标量使用时取最后一个元素
+	meta bool
+	// strs 为 true 表示 Value 为字符串模板
+	strs bool
+}
+
+// scalarShape 返回不带元数据的标量取值范围
+func scalarShape(total interval) shape {
+	return shape{total: total, elem: total, count: pointInterval(1)}
+}
+
+// asScalar 返回作为 d 等运算的标量操作数时的取值范围
+func (s shape) asScalar() interval {
+	if s.meta {
+		return s.elem
+	}
+	return s.total
+}
+
+// elements 返回作为 kh、lp 等运算的多元组操作数时元素与个数的范围
+func (s shape) elements() (interval, interval) {
+	if s.strs {
+		return openInterval(), s.count
+	}
+	if s.meta {
+		return s.elem, s.count
+	}
+	return s.total, pointInterval(1)
+}
+
+// union 返回同时覆盖两个取值范围的描述，用于三元运算的两个分支
+func (s shape) union(o shape) shape {
+	return shape{
+		total: s.total.union(o.total),
+		elem:  s.elem.union(o.elem),
+		count: s.count.union(o.count),
+		meta:  s.meta || o.meta,
+		strs:  s.strs || o.strs,
+	}
+}
+
+// diceShape 返回 NdM 的取值范围；非法的次数与面数会导致求值错误，因此不计入
+func diceShape(times, sides interval) shape {
+	t := times.clamp(1, 10000)
+	s := sides.clamp(1, 10000)
+	elem := interval{lo: 1, hi: s.hi}
+	return shape{total: sumInterval(t, elem), elem: elem, count: t, meta: true}
+}
+
+// canExplode 判断爆炸条件是否可能在某个面上触发
+// 未指定比较点时总在最大面触发；比较值不固定时保守地认为可能触发
+func canExplode(sides interval, op string, cmp interval, explicit bool) bool {
+	if !explicit || !cmp.closed() || cmp.lo != cmp.hi {
+		return true
+	}
+	s := sides.clamp(1, 10000)
+	for f := 1; f <= s.hi; f++ {
+		if compare(f, op, cmp.lo) {
+			return true
+		}
+	}
+	return false
+}
+
+// explodeShape 返回带爆炸修饰的 NdM 的取值范围
+// 可能触发爆炸时上界标记为无界；穿透爆炸追加的骰子可能为 0
+func explodeShape(kind string, times, sides interval, fire bool) shape {
+	out := diceShape(times, sides)
+	if !fire {
+		return out
+	}
+	out.total.hiOpen = true
+	switch kind {
+	case "!!":
+		out.elem.hiOpen = true
+	case "!p":
+		out.elem.lo = 0
+		out.count.hiOpen = true
+	default:
+		out.count.hiOpen = true
+	}
+	return out
+}
+
+// successShape 返回成功计数的取值范围：每颗骰子贡献 -1（失败扣除）到 2（双倍成功）次成功
+// 骰池元素与个数的范围保持不变
+func successShape(pool shape, fail, double bool) shape {
+	per := interval{lo: 0, hi: 1}
+	if fail {
+		per.lo = -1
+	}
+	if double {
+		per.hi = 2
+	}
+	pool.total = sumInterval(pool.count, per)
+	return pool
+}
+
+// chainShape 返回附加链 a 与压缩链 c 的取值范围
+// 只要阈值不大于面数，骰池就可能一直连锁下去，此时上界标记为无界
+func chainShape(op string, times, threshold, faces interval) shape {
+	t := times.clamp(0, 10000)
+	thr := threshold.clamp(1, 10000)
+	m := faces.clamp(1, 10000)
+	canChain := thr.lo <= m.hi && t.hi > 0
+
+	out := shape{elem: interval{lo: 1, hi: m.hi}, count: t, meta: true}
+	if canChain {
+		out.count.hiOpen = true
+	}
+	if op == "a" {
+		out.total = interval{lo: 0, hi: 0, hiOpen: canChain}
+		return out
+	}
+	lo := 0
+	if t.lo > 0 {
+		lo = 1
+	}
+	hi := 0
+	if t.hi > 0 {
+		hi = m.hi
+	}
+	out.total = interval{lo: lo, hi: hi, hiOpen: canChain}
+	return out
+}
+
+// bonusShape 返回奖励骰 b 与惩罚骰 p 的取值范围
+func bonusShape(param interval) shape {
+	n := param.clamp(0, 10000)
+	lo := 1
+	if n.hi > 0 {
+		lo = 0
+	}
+	return shape{
+		total: interval{lo: lo, hi: 100},
+		elem:  interval{lo: 0, hi: 9},
+		count: addInterval(n, pointInterval(2)),
+		meta:  true,
+	}
+}
+
+// binaryShape 返回二元运算的取值范围
+func binaryShape(op string, a, b shape) shape {
+	switch op {
+	case "=":
+		return scalarShape(b.total)
+	case "k", "q", "kh", "kl", "dh", "dl":
+		elem, count := a.elements()
+		n := b.total.atLeast(1)
+		var kept interval
+		if op == "dh" || op == "dl" {
+			kept = addInterval(count, negInterval(n)).atLeast(0)
+		} else {
+			kept = minInterval(count, n)
+		}
+		return shape{total: sumInterval(kept, elem), elem: elem, count: kept, meta: true}
+	case "min", "max":
+		if a.strs {
+			return shape{total: pointInterval(0), elem: pointInterval(0), count: pointInterval(0), meta: true}
+		}
+		elem, count := a.elements()
+		n := b.total.atLeast(1)
+		if op == "max" {
+			elem = minInterval(elem, n)
+		} else {
+			elem = maxInterval(elem, n)
+		}
+		return shape{total: sumInterval(count, elem), elem: elem, count: count, meta: true}
+	case "b", "p":
+		return bonusShape(b.total)
+	case "f":
+		t := a.total.clamp(1, 10000)
+		elem := interval{lo: -1, hi: 1}
+		return shape{total: sumInterval(t, elem), elem: elem, count: t, meta: true}
+	case "sp":
+		if !a.meta {
+			return shape{total: a.total, elem: a.total, count: pointInterval(1), meta: true}
+		}
+		return shape{total: a.elem, elem: a.elem, count: pointInterval(1), meta: true}
+	case "tp":
+		if !a.meta {
+			return shape{total: pointInterval(0), elem: pointInterval(0), count: pointInterval(0)}
+		}
+		count := addInterval(a.count, pointInterval(-1)).atLeast(0)
+		return shape{total: sumInterval(count, a.elem), elem: a.elem, count: count, meta: true}
+	case "lp":
+		n := b.total.atLeast(1)
+		elem, count := a.elements()
+		total := scalarInterval("*", count, n).atLeast(0)
+		if a.strs {
+			return shape{total: pointInterval(0), elem: elem, count: total, strs: true}
+		}
+		return shape{total: sumInterval(total, elem), elem: elem, count: total, meta: true}
+	}
+	return scalarShape(scalarInterval(op, a.total, b.total))
+}
+
+// unaryShape 返回前缀运算的取值范围
+func unaryShape(op string, x shape) shape {
+	switch op {
+	case "!":
+		t := x.total
+		mayZero := t.contains(0)
+		mayNonZero := !t.closed() || t.lo != 0 || t.hi != 0
+		out := interval{lo: 0, hi: 1}
+		if !mayZero {
+			out.hi = 0
+		}
+		if !mayNonZero {
+			out.lo = 1
+		}
+		return scalarShape(out)
+	case "-":
+		return scalarShape(negInterval(x.total))
+	case "+":
+		return scalarShape(x.total)
+	}
+	return scalarShape(openInterval())
+}
+
+// tupleShape 返回多元组字面量的取值范围
+func tupleShape(elems []shape, hasStr bool) shape {
+	out := shape{total: pointInterval(0), count: pointInterval(len(elems)), meta: !hasStr, strs: hasStr}
+	if hasStr {
+		out.elem = openInterval()
+		return out
+	}
+	for i, el := range elems {
+		if i == 0 {
+			out.elem = el.total
+			continue
+		}
+		out.elem = out.elem.union(el.total)
+	}
+	return out
+}
+
+// staticShape 在不掷骰的情况下推导节点的取值范围，用于未被选中的三元分支
+// temps 为临时变量的取值范围，分支内的赋值只影响其副本
+func (e *evaluator) staticShape(n node, temps map[int]shape) shape {
+	switch n := n.(type) {
+	case *numberNode:
+		return scalarShape(pointInterval(n.v))
+	case *stringNode:
+		return shape{total: pointInterval(0), elem: openInterval(), count: pointInterval(1), strs: true}
+	case *tupleNode:
+		elems := make([]shape, 0, len(n.elems))
+		hasStr := false
+		for _, el := range n.elems {
+			if _, ok := el.(*stringNode); ok {
+				hasStr = true
+			}
+			elems = append(elems, e.staticShape(el, temps))
+		}
+		return tupleShape(elems, hasStr)
+	case *tempNode:
+		if s, ok := temps[n.index]; ok {
+			return s
+		}
+		return scalarShape(pointInterval(e.evalTemp(n).V))
+	case *varNode:
+		if v, ok := e.vt[n.name]; ok {
+			return scalarShape(pointInterval(v))
+		}
+		return scalarShape(openInterval())
+	case *groupNode:
+		return e.staticShape(n.x, temps)
+	case *ternaryNode:
+		cond := e.staticShape(n.cond, temps)
+		mayFalse := cond.total.contains(0)
+		mayTrue := !cond.total.closed() || cond.total.lo != 0 || cond.total.hi != 0
+		switch {
+		case mayTrue && mayFalse:
+			return e.staticShape(n.then, copyShapes(temps)).union(e.staticShape(n.els, copyShapes(temps)))
+		case mayTrue:
+			return e.staticShape(n.then, temps)
+		default:
+			return e.staticShape(n.els, temps)
+		}
+	case *diceNode:
+		out := e.staticDiceShape(n, temps)
+		if n.success != nil {
+			out = successShape(out, n.success.fail != nil, n.success.double != nil)
+		}
+		return out
+	case *chainNode:
+		faces := pointInterval(10)
+		times := e.staticShape(n.times, temps).total
+		threshold := e.staticShape(n.threshold, temps).total
+		if n.faces != nil {
+			faces = e.staticShape(n.faces, temps).total
+		}
+		return chainShape(n.op, times, threshold, faces)
+	case *unaryNode:
+		return unaryShape(n.op, e.staticShape(n.x, temps))
+	case *binaryNode:
+		a := e.staticShape(n.left, temps)
+		b := e.staticShape(n.right, temps)
+		if n.op == "=" {
+			if t, ok := n.left.(*tempNode); ok {
+				temps[t.index] = scalarShape(b.total)
+			}
+		}
+		return binaryShape(n.op, a, b)
+	}
+	return scalarShape(openInterval())
+}
+
+// staticDiceShape 推导骰池（含重投与爆炸修饰）的取值范围
+func (e *evaluator) staticDiceShape(n *diceNode, temps map[int]shape) shape {
+	times := e.staticShape(n.times, temps).asScalar()
+	sides := pointInterval(e.defaultFaces)
+	if n.sides != nil {
+		sides = e.staticShape(n.sides, temps).asScalar()
+	}
+	if n.explode == nil {
+		return diceShape(times, sides)
+	}
+	cmpOp, cmp := "=", sides
+	if n.explode.cmp != nil {
+		cmpOp, cmp = n.explode.cmp.op, e.staticShape(n.explode.cmp.value, temps).total
+	}
+	return explodeShape(n.explode.kind, times, sides, canExplode(sides, cmpOp, cmp, n.explode.cmp != nil))
+}
+
+// copyShapes 复制临时变量的取值范围表
+func copyShapes(m map[int]shape) map[int]shape {
+	out := make(map[int]shape, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
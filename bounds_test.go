@@ -0,0 +1,77 @@
+package gonedice
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestResultBounds(t *testing.T) {
+	cases := []struct {
+		expr             string
+		min, max         int
+		minOpen, maxOpen bool
+	}{
+		{"3d6+2", 5, 20, false, false},
+		{"4d6kh3", 3, 18, false, false},
+		{"4d6dl1", 3, 18, false, false},
+		{"1d6-1d6", -5, 5, false, false},
+		{"100/1d6", 16, 100, false, false},
+		{"(1d4)d6", 1, 24, false, false},
+		{"3d10min5", 15, 30, false, false},
+		{"4df", -4, 4, false, false},
+		{"1b2", 0, 100, false, false},
+		{"1d20>10?2d6:1d4", 1, 12, false, false},
+		{"0?1d6:2", 2, 2, false, false},
+		{"3c8m6", 1, 6, false, false},
+		{"3a11", 0, 0, false, false},
+		{"3a5", 0, 0, false, true},
+		{"3c5", 1, 0, false, true},
+	}
+	for _, c := range cases {
+		res := MustCompile(c.expr).Roll()
+		if res.Err != nil {
+			t.Fatalf("%q: unexpected error %v", c.expr, res.Err)
+		}
+		if res.MinOpen != c.minOpen || res.MaxOpen != c.maxOpen {
+			t.Fatalf("%q: expected open %v/%v got %v/%v", c.expr, c.minOpen, c.maxOpen, res.MinOpen, res.MaxOpen)
+		}
+		if (!c.minOpen && res.Min != c.min) || (!c.maxOpen && res.Max != c.max) {
+			t.Fatalf("%q: expected bounds %d..%d got %d..%d", c.expr, c.min, c.max, res.Min, res.Max)
+		}
+	}
+}
+
+func TestBoundsDetail(t *testing.T) {
+	r := New("3d6+2", nil)
+	r.rng = rand.New(rand.NewSource(1))
+	r.Roll()
+	if res := r.Result(); res.Detail != "18 min=5 max=20" {
+		t.Fatalf("unexpected detail %q", res.Detail)
+	}
+
+	r = New("3a5", nil)
+	r.rng = rand.New(rand.NewSource(1))
+	r.Roll()
+	if res := r.Result(); !res.MaxOpen || !strings.HasSuffix(res.Detail, "min=0 max=∞") {
+		t.Fatalf("unexpected detail %q", res.Detail)
+	}
+}
+
+// TestBoundsContainSamples 抽样结果必须落在区间内
+func TestBoundsContainSamples(t *testing.T) {
+	exprs := []string{"1d6*1d6-10", "2^1d4", "1d6&3", "1d6|8", "($t1=1d6)d6+$t1", "2d6d6", "4d6sp2", "4d6tp1", "5d6k2", "1d2?($t=3d6):($t=1)"}
+	for _, expr := range exprs {
+		p := MustCompile(expr)
+		rng := rand.New(rand.NewSource(3))
+		for i := 0; i < 2000; i++ {
+			res := p.Roll(WithRNG(rng))
+			if res.Err != nil {
+				t.Fatalf("%q: unexpected error %v", expr, res.Err)
+			}
+			if (!res.MinOpen && res.Value < res.Min) || (!res.MaxOpen && res.Value > res.Max) {
+				t.Fatalf("%q: value %d outside %d..%d", expr, res.Value, res.Min, res.Max)
+			}
+		}
+	}
+}
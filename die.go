@@ -0,0 +1,116 @@
+package gonedice
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Die 描述一颗骰子的掷骰结果
+// 与 MetaTuple 不同，被 kh、dl、sp 等运算丢弃的骰子仍然保留，并以 Dropped 标记
+type Die struct {
+	// Value 最终点数：复利爆炸为各次点数之和，穿透爆炸追加的骰子已减 1，min/max 限制后的值
+	Value int `json:"value"`
+	// Faces 骰子面数，fudge 骰为 3
+	Faces int `json:"faces"`
+	// Op 产生该骰子的运算符，如 "d"、"f"、"b"、"p"、"a"、"c"
+	Op string `json:"op"`
+	// Dropped 是否被 k/q/kh/kl/dh/dl/sp/tp 等运算丢弃
+	Dropped bool `json:"dropped,omitempty"`
+	// Exploded 是否触发了爆炸，包括 a/c 链中达到阈值而追加骰子的骰子
+	Exploded bool `json:"exploded,omitempty"`
+	// Rerolls 被重投掉的点数，按掷出顺序排列
+	Rerolls []int `json:"rerolls,omitempty"`
+	// Parts 复利爆炸时每次掷出的点数，依次累加为 Value
+	Parts []int `json:"parts,omitempty"`
+	// Critical 是否掷出了最大面（自然最大值），fudge 骰为 +1
+	Critical bool `json:"critical,omitempty"`
+	// Fumble 是否掷出了最小面（自然最小值），fudge 骰为 -1
+	Fumble bool `json:"fumble,omitempty"`
+}
+
+// newDie 构造一颗 faces 面骰子，natural 为掷出的原始点数
+func newDie(op string, faces, natural int) Die {
+	return Die{Value: natural, Faces: faces, Op: op, Critical: natural == faces, Fumble: natural == 1}
+}
+
+// text 渲染单颗骰子：被重投的点数以 → 连接，如 1→4
+// 爆炸的骰子带 ! 标记，复利爆炸列出每次掷出的点数，如 6!+6!+2
+func (d Die) text() string {
+	prefix := ""
+	for _, r := range d.Rerolls {
+		prefix += strconv.Itoa(r) + "→"
+	}
+	return prefix + d.faceText()
+}
+
+// faceText 渲染骰子最终的点数部分
+func (d Die) faceText() string {
+	if len(d.Parts) > 1 {
+		items := make([]string, len(d.Parts))
+		for i, p := range d.Parts {
+			items[i] = strconv.Itoa(p)
+			if i < len(d.Parts)-1 {
+				items[i] += "!"
+			}
+		}
+		return strings.Join(items, "+")
+	}
+	if d.Exploded && d.Op == "d" {
+		return strconv.Itoa(d.Value) + "!"
+	}
+	return strconv.Itoa(d.Value)
+}
+
+// keptDice 若未被丢弃的骰子与 meta 中的元素一一对应，返回与 meta 平行的骰子下标，否则返回 nil
+// meta 可以是骰子的重新排列（例如 kh 的结果按点数排序），点数相同的骰子按原有顺序对应
+func keptDice(dice []Die, meta []int) []int {
+	byValue := map[int][]int{}
+	kept := 0
+	for i, d := range dice {
+		if !d.Dropped {
+			byValue[d.Value] = append(byValue[d.Value], i)
+			kept++
+		}
+	}
+	if kept != len(meta) {
+		return nil
+	}
+	idx := make([]int, len(meta))
+	for j, v := range meta {
+		q := byValue[v]
+		if len(q) == 0 {
+			return nil
+		}
+		idx[j], byValue[v] = q[0], q[1:]
+	}
+	return idx
+}
+
+// dropDice 按元数据下标标记被丢弃的骰子，keep 为保留的元数据下标
+// 骰子与元数据无法对应时原样返回
+func dropDice(v Value, keep []int) []Die {
+	idx := keptDice(v.dice, v.Meta)
+	if idx == nil {
+		return v.dice
+	}
+	kept := make(map[int]bool, len(keep))
+	for _, k := range keep {
+		kept[k] = true
+	}
+	out := append([]Die(nil), v.dice...)
+	for j, di := range idx {
+		if !kept[j] {
+			out[di].Dropped = true
+		}
+	}
+	return out
+}
+
+// joinDice 按求值顺序拼接多个操作数的骰子
+func joinDice(vs ...Value) []Die {
+	var out []Die
+	for _, v := range vs {
+		out = append(out, v.dice...)
+	}
+	return out
+}
@@ -0,0 +1,1077 @@
+package gonedice
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+// maxDistSpan 分布中最小值到最大值的跨度上限，避免构造过大的概率表
+const maxDistSpan = 1 << 20
+
+// keepStepCost 保留类运算的一次状态转移相对于一次卷积乘加的计算量
+const keepStepCost = 16
+
+// Outcome 是分布中的一个取值及其概率
+type Outcome struct {
+	// Value 取值
+	Value int
+	// Prob 概率
+	Prob float64
+}
+
+// Dist 是表达式结果的精确概率分布
+type Dist struct {
+	pmf pmf
+}
+
+// Distribution 计算表达式结果的精确概率分布
+// 支持 d、k/q、kh/kl/dh/dl、b/p、f、min/max、四则运算与乘方、比较、位运算与三元运算；
+// 依赖临时变量、字符串模板或多元组的表达式无法计算分布，会返回错误
+// 计算量超过 Limits.MaxDistWork 时返回 ErrLimitExceeded
+func Distribution(expr string, opts ...Option) (*Dist, error) {
+	return DistributionContext(context.Background(), expr, opts...)
+}
+
+// DistributionContext 与 Distribution 相同，但 ctx 被取消或超时后计算会尽快中止并返回 ErrCancelled
+func DistributionContext(ctx context.Context, expr string, opts ...Option) (*Dist, error) {
+	p, err := Compile(expr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return p.DistributionContext(ctx)
+}
+
+// Distribution 计算编译后表达式结果的精确概率分布
+// 可使用 WithValueTable 提供表达式中引用的变量，WithLimits 限制计算量
+func (p *Program) Distribution(opts ...Option) (*Dist, error) {
+	return p.DistributionContext(context.Background(), opts...)
+}
+
+// DistributionContext 与 Distribution 相同，但 ctx 被取消或超时后计算会尽快中止并返回 ErrCancelled
+func (p *Program) DistributionContext(ctx context.Context, opts ...Option) (*Dist, error) {
+	cfg := p.config(opts)
+	dc := &distCalc{vt: cfg.valueTable, defaultFaces: cfg.defaultFaces, src: p.expr, lim: cfg.limits.resolve(), ctx: ctx}
+	d, err := dc.dist(p.root)
+	if err != nil {
+		return nil, err.localize(cfg.locale)
+	}
+	return &Dist{pmf: d}, nil
+}
+
+// Min 返回可能的最小值
+func (d *Dist) Min() int {
+	return d.pmf.lo
+}
+
+// Max 返回可能的最大值
+func (d *Dist) Max() int {
+	return d.pmf.hi()
+}
+
+// Prob 返回结果恰好等于 n 的概率
+func (d *Dist) Prob(n int) float64 {
+	i := n - d.pmf.lo
+	if i < 0 || i >= len(d.pmf.p) {
+		return 0
+	}
+	return d.pmf.p[i]
+}
+
+// AtLeast 返回结果大于等于 n 的概率 P(X >= n)
+func (d *Dist) AtLeast(n int) float64 {
+	s := 0.0
+	for i := len(d.pmf.p) - 1; i >= 0 && d.pmf.lo+i >= n; i-- {
+		s += d.pmf.p[i]
+	}
+	return math.Min(s, 1)
+}
+
+// AtMost 返回结果小于等于 n 的概率 P(X <= n)
+func (d *Dist) AtMost(n int) float64 {
+	s := 0.0
+	for i := 0; i < len(d.pmf.p) && d.pmf.lo+i <= n; i++ {
+		s += d.pmf.p[i]
+	}
+	return math.Min(s, 1)
+}
+
+// Mean 返回期望
+func (d *Dist) Mean() float64 {
+	m := 0.0
+	for i, p := range d.pmf.p {
+		m += float64(d.pmf.lo+i) * p
+	}
+	return m
+}
+
+// Variance 返回方差
+func (d *Dist) Variance() float64 {
+	mean := d.Mean()
+	v := 0.0
+	for i, p := range d.pmf.p {
+		x := float64(d.pmf.lo+i) - mean
+		v += x * x * p
+	}
+	return v
+}
+
+// StdDev 返回标准差
+func (d *Dist) StdDev() float64 {
+	return math.Sqrt(d.Variance())
+}
+
+// Percentile 返回第 pct 百分位数（pct 取值 0..100）：
+// 即满足 P(X <= v) >= pct/100 的最小取值 v
+func (d *Dist) Percentile(pct float64) int {
+	target := pct / 100
+	s := 0.0
+	for i, p := range d.pmf.p {
+		s += p
+		// 容忍浮点累加误差
+		if s >= target-1e-12 {
+			return d.pmf.lo + i
+		}
+	}
+	return d.pmf.hi()
+}
+
+// Outcomes 按取值升序返回所有概率大于零的结果
+func (d *Dist) Outcomes() []Outcome {
+	out := make([]Outcome, 0, len(d.pmf.p))
+	for i, p := range d.pmf.p {
+		if p > 0 {
+			out = append(out, Outcome{Value: d.pmf.lo + i, Prob: p})
+		}
+	}
+	return out
+}
+
+// pmf 是定义在连续整数区间 [lo, lo+len(p)) 上的概率质量函数
+type pmf struct {
+	lo int
+	p  []float64
+}
+
+// pointPMF 返回恒等于 v 的分布
+func pointPMF(v int) pmf {
+	return pmf{lo: v, p: []float64{1}}
+}
+
+// uniformPMF 返回 [lo, hi] 上的均匀分布
+func uniformPMF(lo, hi int) pmf {
+	n := hi - lo + 1
+	p := make([]float64, n)
+	for i := range p {
+		p[i] = 1 / float64(n)
+	}
+	return pmf{lo: lo, p: p}
+}
+
+func (a pmf) hi() int {
+	return a.lo + len(a.p) - 1
+}
+
+// trim 去掉两端概率为零的取值
+func (a pmf) trim() pmf {
+	i, j := 0, len(a.p)
+	for i < j && a.p[i] == 0 {
+		i++
+	}
+	for j > i && a.p[j-1] == 0 {
+		j--
+	}
+	if i == j {
+		return pointPMF(0)
+	}
+	return pmf{lo: a.lo + i, p: a.p[i:j]}
+}
+
+// each 遍历所有概率大于零的取值
+func (a pmf) each(f func(v int, p float64)) {
+	for i, p := range a.p {
+		if p > 0 {
+			f(a.lo+i, p)
+		}
+	}
+}
+
+// convolve 返回两个独立随机变量之和的分布
+func convolve(a, b pmf) pmf {
+	out := make([]float64, len(a.p)+len(b.p)-1)
+	for i, pa := range a.p {
+		if pa == 0 {
+			continue
+		}
+		for j, pb := range b.p {
+			out[i+j] += pa * pb
+		}
+	}
+	return pmf{lo: a.lo + b.lo, p: out}
+}
+
+// pmfBuilder 按取值累加概率，最后生成稠密的 pmf
+type pmfBuilder map[int]float64
+
+func (b pmfBuilder) build() (pmf, bool) {
+	if len(b) == 0 {
+		return pointPMF(0), true
+	}
+	keys := make([]int, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	lo, hi := keys[0], keys[len(keys)-1]
+	// 取值可能覆盖整个 int 范围（如 2^d 溢出），以无符号数计算跨度
+	if uint64(hi)-uint64(lo) >= maxDistSpan {
+		return pmf{}, false
+	}
+	p := make([]float64, hi-lo+1)
+	for _, k := range keys {
+		p[k-lo] = b[k]
+	}
+	return pmf{lo: lo, p: p}.trim(), true
+}
+
+// distCalc 在语法树上计算分布
+type distCalc struct {
+	vt           map[string]int
+	defaultFaces int
+	src          string
+	lim          Limits
+	// work 已经执行的计算步数
+	work int
+	ctx  context.Context
+}
+
+// spend 记录 steps 步计算，超出 MaxDistWork 或计算已被取消时返回指向 n 的错误
+// 在分配概率表之前调用，使过大的计算在开始前就被拒绝
+func (dc *distCalc) spend(n node, steps int) *Error {
+	dc.work += steps
+	if exceeds(dc.work, dc.lim.MaxDistWork) {
+		return dc.fail(ErrLimitExceeded, n, "", "distribution too expensive")
+	}
+	if dc.ctx != nil {
+		if cerr := dc.ctx.Err(); cerr != nil {
+			err := dc.fail(ErrCancelled, n, "", cerr.Error())
+			err.cause = cerr
+			return err
+		}
+	}
+	return nil
+}
+
+// convolve 返回两个独立随机变量之和的分布，每次乘加计为一步
+func (dc *distCalc) convolve(n node, a, b pmf) (pmf, *Error) {
+	if err := dc.spend(n, len(a.p)*len(b.p)); err != nil {
+		return pmf{}, err
+	}
+	return convolve(a, b), nil
+}
+
+// fail 构造指向节点 n 的错误
+func (dc *distCalc) fail(code ErrorType, n node, op, msg string, args ...any) *Error {
+	return nodeError(code, dc.src, n, op, msg, args...)
+}
+
+// unsupported 构造无法计算分布的错误
+func (dc *distCalc) unsupported(n node, op string) *Error {
+	return dc.fail(ErrNodeOperationInvalid, n, op, "distribution not supported for this expression")
+}
+
+// dist 计算节点结果的分布
+func (dc *distCalc) dist(n node) (pmf, *Error) {
+	switch n := n.(type) {
+	case *numberNode:
+		return pointPMF(n.v), nil
+	case *varNode:
+		if v, ok := dc.vt[n.name]; ok {
+			return pointPMF(v), nil
+		}
+		return pmf{}, dc.fail(ErrInputChildParaInvalid, n, "", "undefined variable %s", n.name)
+	case *groupNode:
+		return dc.dist(n.x)
+	case *ternaryNode:
+		return dc.ternary(n)
+	case *diceNode:
+		return dc.dice(n)
+	case *unaryNode:
+		return dc.unary(n)
+	case *binaryNode:
+		if n.op == "&&" || n.op == "||" {
+			return dc.logical(n)
+		}
+		return dc.binary(n)
+	case *chainNode:
+		return pmf{}, dc.unsupported(n, n.op)
+	}
+	return pmf{}, dc.unsupported(n, "")
+}
+
+// unary 计算前缀运算的分布
+func (dc *distCalc) unary(n *unaryNode) (pmf, *Error) {
+	x, err := dc.dist(n.x)
+	if err != nil {
+		return pmf{}, err
+	}
+	b := pmfBuilder{}
+	x.each(func(v int, p float64) {
+		switch n.op {
+		case "!":
+			b[boolValue(v == 0).V] += p
+		case "-":
+			b[-v] += p
+		case "+":
+			b[v] += p
+		}
+	})
+	out, _ := b.build()
+	return out, nil
+}
+
+// logical 计算短路逻辑运算的分布；左侧必然决定结果时不计算右侧
+func (dc *distCalc) logical(n *binaryNode) (pmf, *Error) {
+	a, err := dc.dist(n.left)
+	if err != nil {
+		return pmf{}, err
+	}
+	pZero := 0.0
+	if 0 >= a.lo && 0 <= a.hi() {
+		pZero = a.p[-a.lo]
+	}
+	// pTrue 为左侧已决定结果为 1 的概率，pRest 为需要右侧决定结果的概率
+	pTrue, pRest := 0.0, 1-pZero
+	if n.op == "||" {
+		pTrue, pRest = 1-pZero, pZero
+	}
+	if pRest > 0 {
+		b, err := dc.dist(n.right)
+		if err != nil {
+			return pmf{}, err
+		}
+		bZero := 0.0
+		if 0 >= b.lo && 0 <= b.hi() {
+			bZero = b.p[-b.lo]
+		}
+		pTrue += pRest * (1 - bZero)
+	}
+	out, _ := pmfBuilder{0: 1 - pTrue, 1: pTrue}.build()
+	return out, nil
+}
+
+// ternary 按条件为真的概率混合两个分支的分布；概率为零的分支不会被计算
+func (dc *distCalc) ternary(n *ternaryNode) (pmf, *Error) {
+	c, err := dc.dist(n.cond)
+	if err != nil {
+		return pmf{}, err
+	}
+	pFalse := 0.0
+	if 0 >= c.lo && 0 <= c.hi() {
+		pFalse = c.p[-c.lo]
+	}
+	pTrue := 1 - pFalse
+
+	b := pmfBuilder{}
+	for _, br := range []struct {
+		n node
+		w float64
+	}{{n.then, pTrue}, {n.els, pFalse}} {
+		if br.w <= 0 {
+			continue
+		}
+		d, err := dc.dist(br.n)
+		if err != nil {
+			return pmf{}, err
+		}
+		d.each(func(v int, p float64) { b[v] += p * br.w })
+	}
+	out, ok := b.build()
+	if !ok {
+		return pmf{}, dc.fail(ErrNodeExtremeValInvalid, n, "?", "distribution too large")
+	}
+	return out, nil
+}
+
+// diceParams 计算掷骰节点的次数分布与面数分布
+func (dc *distCalc) diceParams(n *diceNode) (pmf, pmf, *Error) {
+	times, err := dc.scalarDist(n.times)
+	if err != nil {
+		return pmf{}, pmf{}, err
+	}
+	sides := pointPMF(dc.defaultFaces)
+	if n.sides != nil {
+		if sides, err = dc.scalarDist(n.sides); err != nil {
+			return pmf{}, pmf{}, err
+		}
+	}
+	if times.lo <= 0 || times.hi() > 10000 {
+		return pmf{}, pmf{}, dc.fail(ErrNodeExtremeValInvalid, n.times, "d", "dice count out of range")
+	}
+	if sides.lo <= 0 || sides.hi() > 10000 {
+		return pmf{}, pmf{}, dc.fail(ErrNodeExtremeValInvalid, dc.sidesNode(n), "d", "dice faces out of range")
+	}
+	return times, sides, nil
+}
+
+// scalarDist 计算作为 d 的次数或面数时操作数的分布
+// 带元数据的操作数取最后一个元素：独立同分布骰池的最后一颗骰子即单颗骰子的分布
+func (dc *distCalc) scalarDist(n node) (pmf, *Error) {
+	if !hasMeta(n) {
+		return dc.dist(n)
+	}
+	pl, ok, err := dc.poolOf(n)
+	if err != nil {
+		return pmf{}, err
+	}
+	if !ok {
+		return pmf{}, dc.unsupported(n, "")
+	}
+	return pl.die, nil
+}
+
+// hasMeta 判断节点的结果是否带有元数据（骰池或多元组）
+func hasMeta(n node) bool {
+	switch n := n.(type) {
+	case *diceNode, *chainNode, *tupleNode, *stringNode:
+		return true
+	case *groupNode:
+		return hasMeta(n.x)
+	case *ternaryNode:
+		return hasMeta(n.then) || hasMeta(n.els)
+	case *binaryNode:
+		return !isScalarOp(n.op) && n.op != "="
+	}
+	return false
+}
+
+// sidesNode 返回掷骰面数所在的节点；省略面数时指向整个掷骰表达式
+func (dc *distCalc) sidesNode(n *diceNode) node {
+	if n.sides != nil {
+		return n.sides
+	}
+	return n
+}
+
+// dice 计算 NdM 的分布：按面数分布混合各面数下 N 颗均匀骰子之和的分布
+// 爆炸骰的结果没有上界，不支持计算分布；重投修饰会改变单颗骰子的分布
+// 带成功计数时结果为各骰子成功数之和
+func (dc *distCalc) dice(n *diceNode) (pmf, *Error) {
+	if n.explode != nil {
+		return pmf{}, dc.unsupported(n.explode, n.explode.kind)
+	}
+	times, sides, err := dc.diceParams(n)
+	if err != nil {
+		return pmf{}, err
+	}
+	die, err := dc.dieOf(n)
+	if err != nil {
+		return pmf{}, err
+	}
+	score, err := dc.successOf(n)
+	if err != nil {
+		return pmf{}, err
+	}
+	out := pmfBuilder{}
+	var derr *Error
+	sides.each(func(s int, ps float64) {
+		d, err := die(s)
+		if err != nil {
+			derr = err
+			return
+		}
+		if score != nil {
+			// 成功计数时每颗骰子贡献的成功数相互独立，对贡献值的分布求和即可
+			b := pmfBuilder{}
+			d.each(func(v int, p float64) { b[score(v)] += p })
+			d, _ = b.build()
+		}
+		sum, err := dc.sumOfDice(n, times, d)
+		if err != nil {
+			derr = err
+			return
+		}
+		sum.each(func(v int, p float64) { out[v] += ps * p })
+	})
+	if derr != nil {
+		return pmf{}, derr
+	}
+	res, ok := out.build()
+	if !ok {
+		return pmf{}, dc.fail(ErrNodeExtremeValInvalid, n, "d", "distribution too large")
+	}
+	return res, nil
+}
+
+// constCompare 计算比较点的比较值，比较值必须是常量
+func (dc *distCalc) constCompare(cp *comparePoint) (int, *Error) {
+	d, err := dc.dist(cp.value)
+	if err != nil {
+		return 0, err
+	}
+	if d.lo != d.hi() {
+		return 0, dc.unsupported(cp, cp.op)
+	}
+	return d.lo, nil
+}
+
+// successOf 返回成功计数中单颗骰子点数到成功数的映射；没有成功计数时返回 nil
+func (dc *distCalc) successOf(n *diceNode) (func(int) int, *Error) {
+	mod := n.success
+	if mod == nil {
+		return nil, nil
+	}
+	target, err := dc.constCompare(mod.target)
+	if err != nil {
+		return nil, err
+	}
+	fail, double := 0, 0
+	if mod.fail != nil {
+		if fail, err = dc.constCompare(mod.fail); err != nil {
+			return nil, err
+		}
+	}
+	if mod.double != nil {
+		if double, err = dc.constCompare(mod.double); err != nil {
+			return nil, err
+		}
+	}
+	return func(v int) int {
+		out := 0
+		if compare(v, mod.target.op, target) {
+			out++
+			if mod.double != nil && compare(v, mod.double.op, double) {
+				out++
+			}
+		}
+		if mod.fail != nil && compare(v, mod.fail.op, fail) {
+			out--
+		}
+		return out
+	}, nil
+}
+
+// dieOf 返回掷骰节点中单颗 s 面骰子的分布
+// 带重投修饰时比较值必须是常量；r 最多重投 maxReroll 次，ro 只重投一次
+func (dc *distCalc) dieOf(n *diceNode) (func(s int) (pmf, *Error), *Error) {
+	mod := n.reroll
+	if mod == nil {
+		return func(s int) (pmf, *Error) { return uniformPMF(1, s), nil }, nil
+	}
+	cv, err := dc.constCompare(mod.cmp)
+	if err != nil {
+		return nil, err
+	}
+	limit := maxReroll
+	if mod.once {
+		limit = 1
+	}
+	return func(s int) (pmf, *Error) {
+		match := func(f int) bool { return compare(f, mod.cmp.op, cv) }
+		if !mod.once && matchesEveryFace(s, match) {
+			return pmf{}, dc.fail(ErrInputChildParaInvalid, mod, "r", "reroll condition matches every face")
+		}
+		hits := 0
+		for f := 1; f <= s; f++ {
+			if match(f) {
+				hits++
+			}
+		}
+		// 满足条件的面只有在连续 limit+1 次都满足时才会保留
+		q := float64(hits) / float64(s)
+		keepHit, keepMiss := math.Pow(q, float64(limit))/float64(s), 0.0
+		for k, w := 0, 1.0; k <= limit; k++ {
+			keepMiss += w / float64(s)
+			w *= q
+		}
+		out := pmf{lo: 1, p: make([]float64, s)}
+		for f := 1; f <= s; f++ {
+			if match(f) {
+				out.p[f-1] = keepHit
+			} else {
+				out.p[f-1] = keepMiss
+			}
+		}
+		return out, nil
+	}, nil
+}
+
+// sumOfDice 返回 times 颗独立同分布骰子之和的分布，times 自身也是随机变量
+func (dc *distCalc) sumOfDice(n node, times pmf, die pmf) (pmf, *Error) {
+	b := pmfBuilder{}
+	cur := pointPMF(0)
+	for t := 1; t <= times.hi(); t++ {
+		var err *Error
+		if cur, err = dc.convolve(n, cur, die); err != nil {
+			return pmf{}, err
+		}
+		if t < times.lo {
+			continue
+		}
+		if pt := times.p[t-times.lo]; pt > 0 {
+			cur.each(func(v int, p float64) { b[v] += p * pt })
+		}
+	}
+	out, ok := b.build()
+	if !ok {
+		return pmf{}, dc.fail(ErrNodeExtremeValInvalid, n, "", "distribution too large")
+	}
+	return out, nil
+}
+
+// pool 描述一组独立同分布骰子：次数分布与单颗骰子的分布
+// 用于计算 kh/kl/min/max 等逐颗作用于骰子的运算
+type pool struct {
+	times pmf
+	die   pmf
+}
+
+// poolOf 若节点产生一组独立同分布的骰子则返回其描述
+// 不产生元数据的标量表达式视为单颗“骰子”
+func (dc *distCalc) poolOf(n node) (pool, bool, *Error) {
+	switch n := n.(type) {
+	case *diceNode:
+		if n.explode != nil {
+			return pool{}, false, dc.unsupported(n.explode, n.explode.kind)
+		}
+		times, sides, err := dc.diceParams(n)
+		if err != nil {
+			return pool{}, false, err
+		}
+		if sides.lo != sides.hi() {
+			// 面数不固定时各骰子并不独立，无法按独立同分布处理
+			return pool{}, false, nil
+		}
+		die, err := dc.dieOf(n)
+		if err != nil {
+			return pool{}, false, err
+		}
+		d, err := die(sides.lo)
+		if err != nil {
+			return pool{}, false, err
+		}
+		return pool{times: times, die: d}, true, nil
+	case *binaryNode:
+		if n.op == "f" {
+			times, err := dc.fudgeTimes(n)
+			if err != nil {
+				return pool{}, false, err
+			}
+			return pool{times: times, die: uniformPMF(-1, 1)}, true, nil
+		}
+		if !isScalarOp(n.op) {
+			return pool{}, false, nil
+		}
+	case *groupNode:
+		return dc.poolOf(n.x)
+	case *numberNode, *varNode:
+	default:
+		return pool{}, false, nil
+	}
+	d, err := dc.dist(n)
+	if err != nil {
+		return pool{}, false, err
+	}
+	return pool{times: pointPMF(1), die: d}, true, nil
+}
+
+// isScalarOp 判断二元运算的结果是否为不带元数据的标量
+func isScalarOp(op string) bool {
+	switch op {
+	case "+", "-", "*", "/", "^", "<", ">", "<=", ">=", "==", "!=", "&", "|", "&&", "||":
+		return true
+	}
+	return false
+}
+
+// fudgeTimes 计算 f 运算的次数分布并校验参数
+func (dc *distCalc) fudgeTimes(n *binaryNode) (pmf, *Error) {
+	times, err := dc.dist(n.left)
+	if err != nil {
+		return pmf{}, err
+	}
+	faces, err := dc.dist(n.right)
+	if err != nil {
+		return pmf{}, err
+	}
+	if faces.lo <= 1 || faces.hi() > 10000 {
+		return pmf{}, dc.fail(ErrNodeExtremeValInvalid, n.right, n.op, "fudge faces out of range")
+	}
+	if times.lo <= 0 || times.hi() > 10000 {
+		return pmf{}, dc.fail(ErrNodeExtremeValInvalid, n.left, n.op, "dice count out of range")
+	}
+	return times, nil
+}
+
+// binary 计算二元运算的分布，左右操作数视为相互独立
+func (dc *distCalc) binary(n *binaryNode) (pmf, *Error) {
+	switch n.op {
+	case "f":
+		times, err := dc.fudgeTimes(n)
+		if err != nil {
+			return pmf{}, err
+		}
+		return dc.sumOfDice(n, times, uniformPMF(-1, 1))
+	case "b", "p":
+		return dc.bonus(n)
+	case "k", "q", "kh", "kl", "dh", "dl":
+		return dc.keep(n)
+	case "min", "max":
+		return dc.clamp(n)
+	}
+	if !isScalarOp(n.op) {
+		return pmf{}, dc.unsupported(n, n.op)
+	}
+
+	a, err := dc.dist(n.left)
+	if err != nil {
+		return pmf{}, err
+	}
+	b, err := dc.dist(n.right)
+	if err != nil {
+		return pmf{}, err
+	}
+	if n.op == "+" {
+		sum, err := dc.convolve(n, a, b)
+		if err != nil {
+			return pmf{}, err
+		}
+		return sum.trim(), nil
+	}
+	if err := dc.spend(n, len(a.p)*len(b.p)); err != nil {
+		return pmf{}, err
+	}
+
+	out := pmfBuilder{}
+	var ferr *Error
+	a.each(func(x int, px float64) {
+		b.each(func(y int, py float64) {
+			if ferr != nil {
+				return
+			}
+			v, code := scalarOp(n.op, x, y)
+			if code != "" {
+				side := n.right
+				if code == ErrNodeLeftValInvalid {
+					side = n.left
+				}
+				ferr = dc.fail(code, side, n.op, "operand may be out of range")
+				return
+			}
+			out[v] += px * py
+		})
+	})
+	if ferr != nil {
+		return pmf{}, ferr
+	}
+	res, ok := out.build()
+	if !ok {
+		return pmf{}, dc.fail(ErrNodeExtremeValInvalid, n, n.op, "distribution too large")
+	}
+	return res, nil
+}
+
+// scalarOp 对两个整数执行标量二元运算，与求值器的语义一致
+func scalarOp(op string, a, b int) (int, ErrorType) {
+	switch op {
+	case "+":
+		return a + b, ""
+	case "-":
+		return a - b, ""
+	case "*":
+		return a * b, ""
+	case "/":
+		if b == 0 {
+			return 0, ErrNodeRightValInvalid
+		}
+		return a / b, ""
+	case ">":
+		return boolValue(a > b).V, ""
+	case "<":
+		return boolValue(a < b).V, ""
+	case ">=":
+		return boolValue(a >= b).V, ""
+	case "<=":
+		return boolValue(a <= b).V, ""
+	case "==":
+		return boolValue(a == b).V, ""
+	case "!=":
+		return boolValue(a != b).V, ""
+	case "&&":
+		return boolValue(a != 0 && b != 0).V, ""
+	case "||":
+		return boolValue(a != 0 || b != 0).V, ""
+	case "&":
+		return a & b, ""
+	case "|":
+		return a | b, ""
+	case "^":
+		if a == 0 && b == 0 {
+			return 0, ErrNodeLeftValInvalid
+		}
+		if b < 0 {
+			return 0, ErrNodeRightValInvalid
+		}
+		res := 1
+		for i := 0; i < b; i++ {
+			res *= a
+		}
+		return res, ""
+	}
+	return 0, ErrNodeOperationInvalid
+}
+
+// bonus 计算奖励骰 b 与惩罚骰 p 的分布
+func (dc *distCalc) bonus(n *binaryNode) (pmf, *Error) {
+	left, err := dc.dist(n.left)
+	if err != nil {
+		return pmf{}, err
+	}
+	param, err := dc.dist(n.right)
+	if err != nil {
+		return pmf{}, err
+	}
+	if param.lo < 0 || param.hi() > 10000 {
+		return pmf{}, dc.fail(ErrNodeExtremeValInvalid, n.right, n.op, "bonus dice count out of range")
+	}
+	if left.hi() > 10000 {
+		return pmf{}, dc.fail(ErrNodeExtremeValInvalid, n.left, n.op, "operand out of range")
+	}
+
+	out := pmfBuilder{}
+	param.each(func(cnt int, pc float64) {
+		// tens 为最终十位数字的分布（仅在原十位与个位不同时为 0 的情况下使用）
+		tens := make([]float64, 10)
+		if cnt == 0 {
+			for t := range tens {
+				tens[t] = 0.1
+			}
+		} else {
+			for t := 0; t < 10; t++ {
+				// 额外骰子取最小值（b）或最大值（p）恰为 t 的概率
+				if n.op == "b" {
+					tens[t] = math.Pow(float64(10-t)/10, float64(cnt)) - math.Pow(float64(9-t)/10, float64(cnt))
+				} else {
+					tens[t] = math.Pow(float64(t+1)/10, float64(cnt)) - math.Pow(float64(t)/10, float64(cnt))
+				}
+			}
+		}
+		for u := 0; u < 10; u++ {
+			if u != 0 {
+				for t := 0; t < 10; t++ {
+					out[t*10+u] += pc * 0.1 * tens[t]
+				}
+				continue
+			}
+			// 个位为 0：原十位也为 0 时结果为 100
+			out[100] += pc * 0.1 * 0.1
+			if cnt == 0 {
+				for t := 1; t < 10; t++ {
+					out[t*10] += pc * 0.1 * 0.1
+				}
+				continue
+			}
+			for t := 0; t < 10; t++ {
+				out[t*10] += pc * 0.1 * 0.9 * tens[t]
+			}
+		}
+	})
+	res, _ := out.build()
+	return res, nil
+}
+
+// keep 计算 k/q/kh/kl/dh/dl 的分布
+func (dc *distCalc) keep(n *binaryNode) (pmf, *Error) {
+	pl, ok, err := dc.poolOf(n.left)
+	if err != nil {
+		return pmf{}, err
+	}
+	if !ok {
+		return pmf{}, dc.unsupported(n, n.op)
+	}
+	param, err := dc.dist(n.right)
+	if err != nil {
+		return pmf{}, err
+	}
+	if param.lo <= 0 {
+		return pmf{}, dc.fail(ErrNodeRightValInvalid, n.right, n.op, "count must be positive")
+	}
+
+	out := pmfBuilder{}
+	var ferr *Error
+	pl.times.each(func(t int, pt float64) {
+		param.each(func(k int, pk float64) {
+			if ferr != nil {
+				return
+			}
+			high := true
+			keep := k
+			switch n.op {
+			case "q", "kl":
+				high = false
+			case "dh":
+				high, keep = false, t-k
+			case "dl":
+				keep = t - k
+			}
+			if keep > t {
+				keep = t
+			}
+			if keep <= 0 {
+				out[0] += pt * pk
+				return
+			}
+			d, err := dc.keepSum(n, pl.die, t, keep, high)
+			if err != nil {
+				ferr = err
+				return
+			}
+			d.each(func(v int, p float64) { out[v] += pt * pk * p })
+		})
+	})
+	if ferr != nil {
+		return pmf{}, ferr
+	}
+	res, ok := out.build()
+	if !ok {
+		return pmf{}, dc.fail(ErrNodeExtremeValInvalid, n, n.op, "distribution too large")
+	}
+	return res, nil
+}
+
+// keepSum 返回 n 颗独立同分布骰子中保留最高（high）或最低 k 颗之和的分布
+// 按点数从高到低（或从低到高）依次决定有多少颗骰子取该点数，
+// 状态为（剩余骰子数，已保留数，已保留之和），状态保存在哈希表中，每次状态转移计为 keepStepCost 步
+func (dc *distCalc) keepSum(at node, die pmf, n, k int, high bool) (pmf, *Error) {
+	vals := make([]int, 0, len(die.p))
+	probs := make([]float64, 0, len(die.p))
+	die.each(func(v int, p float64) {
+		vals = append(vals, v)
+		probs = append(probs, p)
+	})
+	if high {
+		for i, j := 0, len(vals)-1; i < j; i, j = i+1, j-1 {
+			vals[i], vals[j] = vals[j], vals[i]
+			probs[i], probs[j] = probs[j], probs[i]
+		}
+	}
+	type state struct{ rem, kept, sum int }
+	cur := map[state]float64{{rem: n}: 1}
+	done := pmfBuilder{}
+
+	// tail[i] 为点数落在 vals[i:] 中的概率
+	tail := make([]float64, len(vals)+1)
+	for i := len(vals) - 1; i >= 0; i-- {
+		tail[i] = tail[i+1] + probs[i]
+	}
+
+	for i, v := range vals {
+		next := map[state]float64{}
+		q := 1.0
+		if tail[i] > 0 {
+			q = probs[i] / tail[i]
+		}
+		for st, p := range cur {
+			if err := dc.spend(at, (st.rem+1)*keepStepCost); err != nil {
+				return pmf{}, err
+			}
+			// j 颗剩余骰子取点数 v，服从二项分布 B(rem, q)
+			for j := 0; j <= st.rem; j++ {
+				pj := binomial(st.rem, j, q)
+				if pj == 0 {
+					continue
+				}
+				take := j
+				if take > k-st.kept {
+					take = k - st.kept
+				}
+				ns := state{rem: st.rem - j, kept: st.kept + take, sum: st.sum + take*v}
+				if ns.kept == k || ns.rem == 0 {
+					done[ns.sum] += p * pj
+					continue
+				}
+				next[ns] += p * pj
+			}
+		}
+		cur = next
+	}
+	for st, p := range cur {
+		done[st.sum] += p
+	}
+	out, ok := done.build()
+	if !ok {
+		return pmf{}, dc.fail(ErrNodeExtremeValInvalid, at, "", "distribution too large")
+	}
+	return out, nil
+}
+
+// binomial 返回二项分布 B(n, q) 取值为 k 的概率
+func binomial(n, k int, q float64) float64 {
+	if q <= 0 {
+		if k == 0 {
+			return 1
+		}
+		return 0
+	}
+	if q >= 1 {
+		if k == n {
+			return 1
+		}
+		return 0
+	}
+	lg := func(x int) float64 {
+		v, _ := math.Lgamma(float64(x + 1))
+		return v
+	}
+	return math.Exp(lg(n) - lg(k) - lg(n-k) + float64(k)*math.Log(q) + float64(n-k)*math.Log(1-q))
+}
+
+// clamp 计算 min/max 的分布：逐颗骰子截断后求和
+func (dc *distCalc) clamp(n *binaryNode) (pmf, *Error) {
+	pl, ok, err := dc.poolOf(n.left)
+	if err != nil {
+		return pmf{}, err
+	}
+	if !ok {
+		return pmf{}, dc.unsupported(n, n.op)
+	}
+	bound, err := dc.dist(n.right)
+	if err != nil {
+		return pmf{}, err
+	}
+	if bound.lo <= 0 {
+		return pmf{}, dc.fail(ErrNodeRightValInvalid, n.right, n.op, "bound must be positive")
+	}
+
+	out := pmfBuilder{}
+	var ferr *Error
+	bound.each(func(lim int, pb float64) {
+		if ferr != nil {
+			return
+		}
+		die := pmfBuilder{}
+		pl.die.each(func(v int, p float64) {
+			if n.op == "max" && v > lim {
+				v = lim
+			} else if n.op == "min" && v < lim {
+				v = lim
+			}
+			die[v] += p
+		})
+		d, _ := die.build()
+		sum, err := dc.sumOfDice(n, pl.times, d)
+		if err != nil {
+			ferr = err
+			return
+		}
+		sum.each(func(v int, p float64) { out[v] += pb * p })
+	})
+	if ferr != nil {
+		return pmf{}, ferr
+	}
+	res, ok := out.build()
+	if !ok {
+		return pmf{}, dc.fail(ErrNodeExtremeValInvalid, n, n.op, "distribution too large")
+	}
+	return res, nil
+}
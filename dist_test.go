@@ -0,0 +1,218 @@
+package gonedice
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func almostEqual(a, b, eps float64) bool {
+	return math.Abs(a-b) <= eps
+}
+
+func TestDistributionBasics(t *testing.T) {
+	d, err := Distribution("3d6+2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Min() != 5 || d.Max() != 20 {
+		t.Fatalf("3d6+2 bounds expected 5..20 got %d..%d", d.Min(), d.Max())
+	}
+	if !almostEqual(d.Mean(), 12.5, 1e-9) || !almostEqual(d.Variance(), 8.75, 1e-9) {
+		t.Fatalf("3d6+2 mean/variance mismatch: %v %v", d.Mean(), d.Variance())
+	}
+	if !almostEqual(d.AtLeast(20), 1.0/216, 1e-12) || !almostEqual(d.AtLeast(5), 1, 1e-12) {
+		t.Fatalf("3d6+2 tail probabilities mismatch: %v %v", d.AtLeast(20), d.AtLeast(5))
+	}
+	neg, err := Distribution("-(3d6+2)")
+	if err != nil || neg.Min() != -20 || neg.Max() != -5 || !almostEqual(neg.Mean(), -12.5, 1e-9) {
+		t.Fatalf("-(3d6+2) mismatch: %v", err)
+	}
+	if d.Percentile(50) != 12 || d.Percentile(0) != 5 || d.Percentile(100) != 20 {
+		t.Fatalf("3d6+2 percentiles mismatch: %d %d %d", d.Percentile(0), d.Percentile(50), d.Percentile(100))
+	}
+	total := 0.0
+	for _, o := range d.Outcomes() {
+		total += o.Prob
+	}
+	if !almostEqual(total, 1, 1e-9) {
+		t.Fatalf("probabilities should sum to 1 got %v", total)
+	}
+}
+
+func TestDistributionKeep(t *testing.T) {
+	d, err := Distribution("4d6kh3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 4d6 drop lowest: well-known mean 15869/1296
+	if !almostEqual(d.Mean(), 15869.0/1296, 1e-9) {
+		t.Fatalf("4d6kh3 mean expected %v got %v", 15869.0/1296, d.Mean())
+	}
+	if !almostEqual(d.Prob(18), 21.0/1296, 1e-12) {
+		t.Fatalf("4d6kh3 P(18) expected %v got %v", 21.0/1296, d.Prob(18))
+	}
+
+	adv, _ := Distribution("2d20kh1")
+	if !almostEqual(adv.AtLeast(20), 39.0/400, 1e-12) {
+		t.Fatalf("advantage P(20) mismatch: %v", adv.AtLeast(20))
+	}
+	dl, _ := Distribution("4d6dl1")
+	if !almostEqual(dl.Mean(), d.Mean(), 1e-9) {
+		t.Fatalf("4d6dl1 should equal 4d6kh3: %v vs %v", dl.Mean(), d.Mean())
+	}
+}
+
+func TestDistributionReroll(t *testing.T) {
+	d, err := Distribution("1d6ro1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a 1 survives only if the reroll is a 1 again
+	if !almostEqual(d.Prob(1), 1.0/36, 1e-12) || !almostEqual(d.Prob(6), 7.0/36, 1e-12) {
+		t.Fatalf("1d6ro1 probabilities mismatch: %v %v", d.Prob(1), d.Prob(6))
+	}
+	r, err := Distribution("1d6r<3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Prob(2) > 1e-12 || !almostEqual(r.Prob(3), 0.25, 1e-12) {
+		t.Fatalf("1d6r<3 probabilities mismatch: %v %v", r.Prob(2), r.Prob(3))
+	}
+	if _, err := Distribution("1d6r<7"); err == nil {
+		t.Fatalf("1d6r<7 should be rejected")
+	}
+}
+
+func TestDistributionSuccessCounting(t *testing.T) {
+	d, err := Distribution("10d10cs>=8f1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// each die: +1 with probability 0.3, -1 with probability 0.1
+	if d.Min() != -10 || d.Max() != 10 || !almostEqual(d.Mean(), 2, 1e-9) {
+		t.Fatalf("10d10cs>=8f1 mismatch: %d..%d mean %v", d.Min(), d.Max(), d.Mean())
+	}
+	dbl, err := Distribution("10d10cs>=7ds10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !almostEqual(dbl.Mean(), 5, 1e-9) || !almostEqual(dbl.Prob(20), 1e-10, 1e-15) {
+		t.Fatalf("10d10cs>=7ds10 mismatch: mean %v P(20) %v", dbl.Mean(), dbl.Prob(20))
+	}
+}
+
+func TestDistributionLogical(t *testing.T) {
+	d, err := Distribution("(1d6)>=5 || (1d6)==1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 1 - (4/6)*(5/6)
+	if !almostEqual(d.Prob(1), 1-20.0/36, 1e-12) {
+		t.Fatalf("|| probability mismatch: %v", d.Prob(1))
+	}
+	n, err := Distribution("!(1d4-1) && 1/(1d2-1)")
+	if err == nil {
+		t.Fatalf("division by zero on the right side should still be reported, got %v", n.Outcomes())
+	}
+	s, err := Distribution("0 && 1/0")
+	if err != nil || s.Prob(0) != 1 {
+		t.Fatalf("short-circuited right side should not be computed: %v", err)
+	}
+}
+
+func TestDistributionTernaryAndVars(t *testing.T) {
+	d, err := Distribution("1d20+{STR}>14?2d6:0", WithValueTable(map[string]int{"STR": 5}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// P(1d20 > 9) = 11/20, mean of 2d6 = 7
+	if !almostEqual(d.Mean(), 11.0/20*7, 1e-9) {
+		t.Fatalf("ternary mean mismatch: %v", d.Mean())
+	}
+	if !almostEqual(d.Prob(0), 9.0/20, 1e-12) {
+		t.Fatalf("ternary P(0) mismatch: %v", d.Prob(0))
+	}
+}
+
+func TestDistributionUnsupported(t *testing.T) {
+	for _, expr := range []string{"$t=1d6", "3a5", "\"{i}\"lp3", "4d6sp2", "1d6/(1d2-1)", "3d6!", "2d6r(1d2)"} {
+		if _, err := Distribution(expr); err == nil {
+			t.Fatalf("%q: expected error", expr)
+		}
+	}
+}
+
+func TestDistributionOverflow(t *testing.T) {
+	// ^ can wrap around int, the span check must not overflow as well
+	vt := map[string]int{"X": 3}
+	for _, expr := range []string{"2^d", "6^p", "{X}^d"} {
+		_, err := Distribution(expr, WithValueTable(vt))
+		if !errors.Is(err, ErrNodeExtremeValInvalid) {
+			t.Fatalf("%q: expected NODE_EXTREME_VAL_INVALID got %v", expr, err)
+		}
+	}
+}
+
+// TestDistributionMatchesSampling 以抽样结果校验分布的期望
+func TestDistributionMatchesSampling(t *testing.T) {
+	for _, expr := range []string{"1b3", "1p2", "3d10min5", "4df", "(1d4)d6", "4d6dh1", "2d6r<3", "4d6ro1kh3", "6d10cs>=7f1ds10", "(4d6)kh3", "(2d6)d6"} {
+		d, err := Distribution(expr)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", expr, err)
+		}
+		p := MustCompile(expr)
+		rng := rand.New(rand.NewSource(20240601))
+		const n = 20000
+		sum := 0.0
+		for i := 0; i < n; i++ {
+			res := p.Roll(WithRNG(rng))
+			if res.Value < d.Min() || res.Value > d.Max() {
+				t.Fatalf("%q: sampled %d outside %d..%d", expr, res.Value, d.Min(), d.Max())
+			}
+			sum += float64(res.Value)
+		}
+		tol := 5 * d.StdDev() / math.Sqrt(n)
+		if !almostEqual(sum/n, d.Mean(), tol) {
+			t.Fatalf("%q: sampled mean %v differs from exact %v (tol %v)", expr, sum/n, d.Mean(), tol)
+		}
+	}
+}
+
+func TestDistributionLimits(t *testing.T) {
+	// every convolution and keep transition is charged against MaxDistWork
+	start := time.Now()
+	for _, c := range []struct {
+		expr string
+		lim  Limits
+	}{
+		{"500d1000", Limits{}},
+		{"100d100kh50", Limits{MaxDistWork: 1_000_000}},
+		{"100d100", Limits{MaxDistWork: 1000}},
+		{"1d100*1d100", Limits{MaxDistWork: 1000}},
+	} {
+		_, err := Distribution(c.expr, WithLimits(c.lim))
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("%q: expected LIMIT_EXCEEDED got %v", c.expr, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("limited distributions took %v", elapsed)
+	}
+	if d, err := MustCompile("100d100").Distribution(); err != nil || d.Max() != 10000 {
+		t.Fatalf("100d100 should fit the default limit: %v", err)
+	}
+	if _, err := Distribution("20d6kh10", WithLimits(Limits{MaxDistWork: -1})); err != nil {
+		t.Fatalf("negative MaxDistWork should not limit: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := DistributionContext(ctx, "3d6")
+	if !errors.Is(err, ErrCancelled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled context should abort: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package gonedice
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error 是带有位置信息的解析或求值错误
+// Offset 与 End 是相对于原始表达式的字节偏移，[Offset, End) 为出错区间
+type Error struct {
+	// Code 错误类型
+	Code ErrorType `json:"code"`
+	// Expr 出错的原始表达式
+	Expr string `json:"expr"`
+	// Offset 出错区间的起始字节偏移
+	Offset int `json:"offset"`
+	// End 出错区间的结束字节偏移（不含）
+	End int `json:"end"`
+	// Token 出错区间对应的原始文本
+	Token string `json:"token,omitempty"`
+	// Op 出错时正在处理的运算符，可能为空
+	Op string `json:"op,omitempty"`
+	// Msg 补充说明
+	Msg string `json:"msg,omitempty"`
+	// cause 导致该错误的底层错误，例如求值被取消时的 context.Canceled
+	cause error
+	// format 与 args 是 Msg 的格式串与参数，format 同时是消息目录中的键
+	format string
+	args   []any
+	// locale Error() 使用的消息目录，为 nil 时输出默认文本
+	locale *Locale
+}
+
+// newError 构造指向 expr[start:end] 的错误，msg 为格式串，有 args 时按 fmt.Sprintf 格式化
+func newError(code ErrorType, expr string, start, end int, op, msg string, args ...any) *Error {
+	if start < 0 {
+		start = 0
+	}
+	if start > len(expr) {
+		start = len(expr)
+	}
+	if end < start {
+		end = start
+	}
+	if end > len(expr) {
+		end = len(expr)
+	}
+	text := msg
+	if len(args) > 0 {
+		text = fmt.Sprintf(msg, args...)
+	}
+	return &Error{
+		Code:   code,
+		Expr:   expr,
+		Offset: start,
+		End:    end,
+		Token:  expr[start:end],
+		Op:     op,
+		Msg:    text,
+		format: msg,
+		args:   args,
+	}
+}
+
+// nodeError 构造指向语法树节点 n 的错误
+func nodeError(code ErrorType, expr string, n node, op, msg string, args ...any) *Error {
+	start, end := n.span()
+	return newError(code, expr, start, end, op, msg, args...)
+}
+
+// localize 为尚未指定消息目录的错误设置 loc，e 可以为 nil
+func (e *Error) localize(loc *Locale) *Error {
+	if e != nil && e.locale == nil {
+		e.locale = loc
+	}
+	return e
+}
+
+// Error 实现 error 接口，使用求值时 WithLocale 指定的消息目录
+func (e *Error) Error() string {
+	return e.Localized(e.locale)
+}
+
+// Localized 使用消息目录 loc 渲染错误，loc 为 nil 时输出默认文本，例如：
+//
+//	NODE_RIGHT_VAL_INVALID 节点右侧值无效: division by zero (op "/") at 2 near "0"
+func (e *Error) Localized(loc *Locale) string {
+	var sb strings.Builder
+	sb.WriteString(loc.errorType(e.Code))
+	if e.Msg != "" {
+		sb.WriteString(loc.text("error.sep", ": "))
+		if e.format != "" {
+			sb.WriteString(loc.format(e.format, e.args...))
+		} else {
+			sb.WriteString(e.Msg)
+		}
+	}
+	if e.Op != "" {
+		sb.WriteString(loc.format("error.op", e.Op))
+	}
+	col, _ := e.RuneSpan()
+	sb.WriteString(loc.format("error.at", col))
+	if e.Token != "" {
+		sb.WriteString(loc.format("error.near", e.Token))
+	}
+	return sb.String()
+}
+
+// Unwrap 返回导致该错误的底层错误，使 errors.Is(err, context.DeadlineExceeded) 等判断可用
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is 使 errors.Is(err, ErrNodeRightValInvalid) 等按错误类型判断的写法可用
+func (e *Error) Is(target error) bool {
+	t, ok := target.(ErrorType)
+	return ok && t == e.Code
+}
+
+// RuneSpan 返回出错区间以字符（rune）计的起止位置
+func (e *Error) RuneSpan() (int, int) {
+	return utf8.RuneCountInString(e.Expr[:e.Offset]), utf8.RuneCountInString(e.Expr[:e.End])
+}
+
+// Caret 返回带插入符号的多行描述，例如：
+//
+//	2d6k(
+//	     ^
+//	NODE_STACK_EMPTY 节点栈为空: missing operand at 5
+func (e *Error) Caret() string {
+	var sb strings.Builder
+	sb.WriteString(e.Expr)
+	sb.WriteByte('\n')
+	for _, ch := range e.Expr[:e.Offset] {
+		if ch == '\t' {
+			sb.WriteByte('\t')
+			continue
+		}
+		sb.WriteString(strings.Repeat(" ", runeWidth(ch)))
+	}
+	width := 0
+	for _, ch := range e.Expr[e.Offset:e.End] {
+		width += runeWidth(ch)
+	}
+	if width == 0 {
+		width = 1
+	}
+	sb.WriteString(strings.Repeat("^", width))
+	sb.WriteByte('\n')
+	sb.WriteString(e.Error())
+	return sb.String()
+}
+
+// Format 实现 fmt.Formatter：%+v 输出 Caret 形式，其余动词输出 Error()
+func (e *Error) Format(f fmt.State, verb rune) {
+	if verb == 'v' && f.Flag('+') {
+		io.WriteString(f, e.Caret())
+		return
+	}
+	io.WriteString(f, e.Error())
+}
+
+// runeWidth 返回字符在等宽终端中占用的列数，东亚宽字符占两列
+func runeWidth(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115F,
+		r >= 0x2E80 && r <= 0xA4CF,
+		r >= 0xAC00 && r <= 0xD7A3,
+		r >= 0xF900 && r <= 0xFAFF,
+		r >= 0xFE30 && r <= 0xFE4F,
+		r >= 0xFF00 && r <= 0xFF60,
+		r >= 0xFFE0 && r <= 0xFFE6:
+		return 2
+	}
+	return 1
+}
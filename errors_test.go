@@ -0,0 +1,117 @@
+package gonedice
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestErrorPositions(t *testing.T) {
+	cases := []struct {
+		expr   string
+		code   ErrorType
+		offset int
+		end    int
+		op     string
+	}{
+		{"2d6k(", ErrNodeStackEmpty, 5, 5, "k"},
+		{"1+\"abc", ErrInputRawInvalid, 2, 6, ""},
+		{"1d6#2", ErrInputRawInvalid, 3, 4, ""},
+		{"(1+2", ErrInputRawInvalid, 0, 1, "+"},
+		{"1+2)", ErrInputNodeOperationInvalid, 3, 4, "+"},
+		{"1?2", ErrInputNodeOperationInvalid, 1, 2, "?"},
+		{"3+abc", ErrInputNodeOperationInvalid, 2, 5, "+"},
+	}
+	for _, c := range cases {
+		_, err := Compile(c.expr)
+		perr, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("%q: expected *Error got %T (%v)", c.expr, err, err)
+		}
+		if perr.Code != c.code || perr.Offset != c.offset || perr.End != c.end || perr.Op != c.op {
+			t.Fatalf("%q: expected %v [%d,%d) op %q got %v [%d,%d) op %q",
+				c.expr, c.code, c.offset, c.end, c.op, perr.Code, perr.Offset, perr.End, perr.Op)
+		}
+	}
+}
+
+func TestRuntimeErrorPositions(t *testing.T) {
+	r := New("1+2d0", nil)
+	r.Roll()
+	res := r.Result()
+	if res.Err == nil || res.Error != ErrNodeExtremeValInvalid {
+		t.Fatalf("expected out of range error got %v", res.Error)
+	}
+	if res.Err.Token != "0" || res.Err.Op != "d" || res.Err.Offset != 4 {
+		t.Fatalf("unexpected error span: %+v", *res.Err)
+	}
+
+	r2 := New("10/(1-1)", nil)
+	r2.Roll()
+	if err := r2.Result().Err; err == nil || err.Token != "(1-1)" || err.Op != "/" {
+		t.Fatalf("division by zero should point at divisor, got %+v", err)
+	}
+}
+
+func TestErrorCaret(t *testing.T) {
+	_, err := Compile("1d6+力")
+	perr := err.(*Error)
+	if start, end := perr.RuneSpan(); start != 4 || end != 5 {
+		t.Fatalf("rune span expected [4,5) got [%d,%d)", start, end)
+	}
+	lines := strings.Split(perr.Caret(), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("caret output expected 3 lines got %q", perr.Caret())
+	}
+	if lines[1] != "    ^^" {
+		t.Fatalf("caret line mismatch: %q", lines[1])
+	}
+	if got := fmt.Sprintf("%+v", perr); got != perr.Caret() {
+		t.Fatalf("%%+v should render caret form, got %q", got)
+	}
+	if got := fmt.Sprintf("%v", perr); got != perr.Error() {
+		t.Fatalf("%%v should render Error(), got %q", got)
+	}
+}
+
+func TestErrorTaxonomy(t *testing.T) {
+	cases := map[string]ErrorType{
+		"1/0":          ErrNodeRightValInvalid,
+		"1d10001":      ErrNodeExtremeValInvalid,
+		"1d6 xyz":      ErrInputNodeOperationInvalid,
+		"{HP}+1":       ErrInputChildParaInvalid,
+		"5sp2":         ErrNodeSubValInvalid,
+		"[1,2,3]sp4":   ErrNodeSubValInvalid,
+		"0^0":          ErrNodeLeftValInvalid,
+		"1+":           ErrNodeStackEmpty,
+		"1d6#":         ErrInputRawInvalid,
+		"1d6r<7":       ErrInputChildParaInvalid,
+		"10001d6":      ErrNodeExtremeValInvalid,
+		"[1,2]kh(1/0)": ErrNodeRightValInvalid,
+	}
+	for expr, want := range cases {
+		res := rollExpr(t, expr)
+		if res.Error != want {
+			t.Fatalf("%q: expected %v got %v (%v)", expr, want, res.Error, res.Err)
+		}
+		wrapped := fmt.Errorf("roll failed: %w", res.Err)
+		if !errors.Is(wrapped, want) || errors.Is(wrapped, ErrUnknownGenerate) {
+			t.Fatalf("%q: errors.Is should match only %v", expr, want)
+		}
+	}
+	if _, err := Distribution("1d6lp2"); !errors.Is(err, ErrNodeOperationInvalid) {
+		t.Fatalf("unsupported distribution should be NODE_OPERATION_INVALID: %v", err)
+	}
+}
+
+// rollExpr rolls expr, turning compile errors into a Result
+func rollExpr(t *testing.T, expr string) Result {
+	t.Helper()
+	p, err := Compile(expr)
+	if err != nil {
+		e := err.(*Error)
+		return Result{Error: e.Code, Err: e}
+	}
+	return p.Roll()
+}
@@ -0,0 +1,1051 @@
+package gonedice
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// evaluator 保存一次求值过程中的可变状态
+type evaluator struct {
+	// rng 随机数来源
+	rng Source
+	// vt 变量值表；赋值运算会写入 Tn 键
+	vt map[string]int
+	// temp 临时变量表
+	temp map[int]int
+	// defaultFaces 省略面数时 d 使用的默认面数
+	defaultFaces int
+	// sortedKeep 为 true 时 k/q/kh/kl/dh/dl 的结果按点数排序，否则保持掷出顺序
+	sortedKeep bool
+	// strictVars 为 true 时读取未赋值的临时变量会报错
+	strictVars bool
+	// src 语法树对应的原始表达式，用于取回子表达式文本
+	src string
+	// shapes 本次求值中被赋值的临时变量的取值范围
+	shapes map[int]shape
+	// lim 资源限制，budget 已使用的资源
+	lim    Limits
+	budget *budget
+	// ctx 求值的上下文，取消或超时后求值尽快以 ErrCancelled 结束
+	ctx context.Context
+	// record 不为 nil 时记录每次取得的随机数
+	record *Record
+	// tracing 为 true 时记录步骤树，traces 收集当前节点已求值的输入
+	tracing bool
+	traces  []*Trace
+	// renderer 生成 Result.Detail 的渲染器，为 nil 时使用 PlainRenderer
+	renderer Renderer
+	// locale 错误信息与 Detail 标签使用的消息目录
+	locale *Locale
+	// normalize 为 true 时字符串子表达式同样转换全角字符与中文标点
+	normalize bool
+}
+
+// eval 对语法树节点求值，并将取值范围写入 Value 的 Min/Max
+// 每个节点计为一次运算，求值后检查掷骰数与元数据长度是否超出限制
+func (e *evaluator) eval(n node) (Value, *Error) {
+	if err := e.spend(n, 1); err != nil {
+		return Value{}, err
+	}
+	var siblings []*Trace
+	if e.tracing {
+		siblings, e.traces = e.traces, nil
+	}
+	v, err := e.evalNode(n)
+	if err != nil {
+		return Value{}, err
+	}
+	if exceeds(e.budget.dice, e.lim.MaxDice) {
+		return Value{}, e.fail(ErrLimitExceeded, n, "", "too many dice rolled")
+	}
+	if exceeds(len(v.Meta)+len(v.MetaStr), e.lim.MaxTupleLen) {
+		return Value{}, e.fail(ErrLimitExceeded, n, "", "tuple too long")
+	}
+	t := v.shape.total
+	v.Min, v.Max, v.MinOpen, v.MaxOpen = t.lo, t.hi, t.loOpen, t.hiOpen
+	if e.tracing {
+		start, end := n.span()
+		e.traces = append(siblings, &Trace{Op: nodeOp(n), Expr: e.src[start:end], Value: v.V, MetaTuple: metaTuple(v), Dice: v.dice, Inputs: e.traces})
+	}
+	return v, nil
+}
+
+// spend 记录 ops 次运算，超出 MaxOps 或求值已被取消时返回指向 n 的错误
+func (e *evaluator) spend(n node, ops int) *Error {
+	e.budget.ops += ops
+	if exceeds(e.budget.ops, e.lim.MaxOps) {
+		return e.fail(ErrLimitExceeded, n, "", "too many operations")
+	}
+	return e.checkCancel(n)
+}
+
+// checkCancel 检查上下文是否已被取消
+func (e *evaluator) checkCancel(n node) *Error {
+	if e.ctx == nil {
+		return nil
+	}
+	select {
+	case <-e.ctx.Done():
+		err := e.fail(ErrCancelled, n, "", e.ctx.Err().Error())
+		err.cause = e.ctx.Err()
+		return err
+	default:
+		return nil
+	}
+}
+
+// tick 在循环中每隔若干次迭代检查一次上下文是否已被取消
+func (e *evaluator) tick(n node, i int) *Error {
+	if i&1023 != 0 {
+		return nil
+	}
+	return e.checkCancel(n)
+}
+
+// intn 掷出一颗骰子，返回 [0, n) 内的随机整数并计入 MaxDice
+func (e *evaluator) intn(n int) int {
+	e.budget.dice++
+	v := e.rng.Intn(n)
+	if e.record != nil {
+		e.record.Draws = append(e.record.Draws, Draw{Faces: n, Value: v + 1})
+	}
+	return v
+}
+
+// evalNode 按节点类型分派求值
+func (e *evaluator) evalNode(n node) (Value, *Error) {
+	switch n := n.(type) {
+	case *numberNode:
+		return Value{V: n.v, shape: scalarShape(pointInterval(n.v))}, nil
+	case *stringNode:
+		return Value{V: 0, MetaEnable: true, MetaStr: []string{n.s}, shape: tupleShape([]shape{{}}, true)}, nil
+	case *tupleNode:
+		return e.evalTuple(n)
+	case *tempNode:
+		if _, ok := e.lookupTemp(n.index); !ok && e.strictVars {
+			return Value{}, e.fail(ErrInputChildParaInvalid, n, "", "temp variable $%d is not assigned", n.index)
+		}
+		v := e.evalTemp(n)
+		if s, ok := e.shapes[n.index]; ok {
+			v.shape = s
+		}
+		return v, nil
+	case *varNode:
+		if v, ok := e.vt[n.name]; ok {
+			return Value{V: v, shape: scalarShape(pointInterval(v))}, nil
+		}
+		return Value{}, e.fail(ErrInputChildParaInvalid, n, "", "undefined variable %s", n.name)
+	case *groupNode:
+		// 括号只改变结合顺序，保留元数据与临时变量信息
+		return e.eval(n.x)
+	case *ternaryNode:
+		return e.evalTernary(n)
+	case *diceNode:
+		return e.evalDice(n)
+	case *chainNode:
+		return e.evalChain(n)
+	case *unaryNode:
+		return e.evalUnary(n)
+	case *binaryNode:
+		if n.op == "&&" || n.op == "||" {
+			return e.evalLogical(n)
+		}
+		return e.evalBinary(n)
+	}
+	return Value{}, e.fail(ErrUnknownGenerate, n, "", "unknown node")
+}
+
+// evalTernary 三元运算：只对选中的分支求值
+// 条件可能取到另一侧时，未选中分支的取值范围通过静态推导并入结果
+func (e *evaluator) evalTernary(n *ternaryNode) (Value, *Error) {
+	c, err := e.eval(n.cond)
+	if err != nil {
+		return Value{}, err
+	}
+	taken, other := n.then, n.els
+	if c.V == 0 {
+		taken, other = n.els, n.then
+	}
+	cond := c.shape.total
+	mayFalse := cond.contains(0)
+	mayTrue := !cond.closed() || cond.lo != 0 || cond.hi != 0
+	temps := copyShapes(e.shapes)
+
+	v, err := e.eval(taken)
+	if err != nil {
+		return Value{}, err
+	}
+	if mayTrue && mayFalse {
+		v.shape = v.shape.union(e.staticShape(other, temps))
+	}
+	v.dice = joinDice(c, v)
+	return v, nil
+}
+
+// evalUnary 前缀运算
+func (e *evaluator) evalUnary(n *unaryNode) (Value, *Error) {
+	x, err := e.eval(n.x)
+	if err != nil {
+		return Value{}, err
+	}
+	switch n.op {
+	case "!": // 逻辑非
+		v := boolValue(x.V == 0)
+		v.shape, v.dice = unaryShape(n.op, x.shape), x.dice
+		return v, nil
+	case "-":
+		return Value{V: -x.V, dice: x.dice, shape: unaryShape(n.op, x.shape)}, nil
+	case "+":
+		return Value{V: x.V, dice: x.dice, shape: unaryShape(n.op, x.shape)}, nil
+	}
+	return Value{}, e.fail(ErrNodeOperationInvalid, n, n.op, "unknown operator")
+}
+
+// evalLogical 短路逻辑运算 && 与 ||，结果为 0 或 1
+// 左侧已能决定结果时不对右侧求值，右侧的取值范围通过静态推导并入结果
+func (e *evaluator) evalLogical(n *binaryNode) (Value, *Error) {
+	a, err := e.eval(n.left)
+	if err != nil {
+		return Value{}, err
+	}
+	temps := copyShapes(e.shapes)
+	if (n.op == "&&" && a.V == 0) || (n.op == "||" && a.V != 0) {
+		v := boolValue(a.V != 0)
+		v.shape, v.dice = binaryShape(n.op, a.shape, e.staticShape(n.right, temps)), a.dice
+		return v, nil
+	}
+	b, err := e.eval(n.right)
+	if err != nil {
+		return Value{}, err
+	}
+	v := boolValue(b.V != 0)
+	v.shape, v.dice = binaryShape(n.op, a.shape, b.shape), joinDice(a, b)
+	return v, nil
+}
+
+// fail 构造指向节点 n 的求值错误
+func (e *evaluator) fail(code ErrorType, n node, op, msg string, args ...any) *Error {
+	return nodeError(code, e.src, n, op, msg, args...)
+}
+
+// sidesNode 返回掷骰面数所在的节点；省略面数时指向整个掷骰表达式
+func (e *evaluator) sidesNode(n *diceNode) node {
+	if n.sides != nil {
+		return n.sides
+	}
+	return n
+}
+
+// evalTuple 对多元组字面量求值
+// 若包含字符串元素，则整体作为字符串模板保存，其余元素保留原始文本以便延迟求值
+func (e *evaluator) evalTuple(n *tupleNode) (Value, *Error) {
+	hasStr := false
+	for _, el := range n.elems {
+		if _, ok := el.(*stringNode); ok {
+			hasStr = true
+			break
+		}
+	}
+
+	if hasStr {
+		strs := make([]string, 0, len(n.elems))
+		for _, el := range n.elems {
+			if s, ok := el.(*stringNode); ok {
+				strs = append(strs, s.s)
+				continue
+			}
+			strs = append(strs, e.source(el))
+		}
+		return Value{V: 0, MetaEnable: true, MetaStr: strs, shape: tupleShape(make([]shape, len(strs)), true)}, nil
+	}
+
+	ints := make([]int, 0, len(n.elems))
+	shapes := make([]shape, 0, len(n.elems))
+	var dice []Die
+	for _, el := range n.elems {
+		v, err := e.eval(el)
+		if err != nil {
+			return Value{}, err
+		}
+		ints = append(ints, v.V)
+		shapes = append(shapes, v.shape)
+		dice = append(dice, v.dice...)
+	}
+	return Value{V: 0, Meta: ints, MetaEnable: true, dice: dice, shape: tupleShape(shapes, false)}, nil
+}
+
+// source 返回节点对应的原始表达式文本
+func (e *evaluator) source(n node) string {
+	start, end := n.span()
+	return strings.TrimSpace(e.src[start:end])
+}
+
+// evalTemp 读取临时变量：优先使用 temp，未设置时再查 ValueTable 中的 Tn
+func (e *evaluator) evalTemp(n *tempNode) Value {
+	val, _ := e.lookupTemp(n.index)
+	return Value{V: val, TempIndex: n.index, IsTemp: true, shape: scalarShape(pointInterval(val))}
+}
+
+// lookupTemp 查找临时变量的值，未赋值时返回 false
+func (e *evaluator) lookupTemp(index int) (int, bool) {
+	if vv, ok := e.temp[index]; ok {
+		return vv, true
+	}
+	key := fmt.Sprintf("T%d", index)
+	if vv, ok := e.vt[key]; ok {
+		return vv, true
+	}
+	if vv, ok := e.vt[strings.ToLower(key)]; ok {
+		return vv, true
+	}
+	return 0, false
+}
+
+// lastOrValue 多元组作为标量使用时取最后一个元素，否则取数值
+func lastOrValue(v Value) int {
+	if v.MetaEnable && len(v.Meta) > 0 {
+		return v.Meta[len(v.Meta)-1]
+	}
+	return v.V
+}
+
+// evalDice 掷骰运算 NdM，带成功计数修饰时结果为成功数
+func (e *evaluator) evalDice(n *diceNode) (Value, *Error) {
+	v, err := e.rollDice(n)
+	if err != nil || n.success == nil {
+		return v, err
+	}
+	return e.countSuccesses(n.success, v)
+}
+
+// countSuccesses 成功计数：统计骰池中满足目标的骰子个数作为结果，骰池本身保留在元数据中
+// 满足 fail 条件的骰子扣除一次成功，满足 double 条件的成功骰子额外计一次
+func (e *evaluator) countSuccesses(mod *successMod, pool Value) (Value, *Error) {
+	target, err := e.eval(mod.target.value)
+	if err != nil {
+		return Value{}, err
+	}
+	failV, doubleV := Value{}, Value{}
+	if mod.fail != nil {
+		if failV, err = e.eval(mod.fail.value); err != nil {
+			return Value{}, err
+		}
+	}
+	if mod.double != nil {
+		if doubleV, err = e.eval(mod.double.value); err != nil {
+			return Value{}, err
+		}
+	}
+
+	count := 0
+	for _, v := range pool.Meta {
+		if compare(v, mod.target.op, target.V) {
+			count++
+			if mod.double != nil && compare(v, mod.double.op, doubleV.V) {
+				count++
+			}
+		}
+		if mod.fail != nil && compare(v, mod.fail.op, failV.V) {
+			count--
+		}
+	}
+	pool.V = count
+	pool.shape = successShape(pool.shape, mod.fail != nil, mod.double != nil)
+	return pool, nil
+}
+
+// rollDice 掷出 NdM 骰池，包括重投与爆炸修饰
+func (e *evaluator) rollDice(n *diceNode) (Value, *Error) {
+	timesV, err := e.eval(n.times)
+	if err != nil {
+		return Value{}, err
+	}
+	sides := e.defaultFaces
+	sidesRange := pointInterval(sides)
+	if n.sides != nil {
+		sidesV, err := e.eval(n.sides)
+		if err != nil {
+			return Value{}, err
+		}
+		sides = lastOrValue(sidesV)
+		sidesRange = sidesV.shape.asScalar()
+	}
+	times := lastOrValue(timesV)
+
+	if times <= 0 || times > 10000 {
+		return Value{}, e.fail(ErrNodeExtremeValInvalid, n.times, "d", "dice count out of range")
+	}
+	if sides <= 0 || sides > 10000 {
+		return Value{}, e.fail(ErrNodeExtremeValInvalid, e.sidesNode(n), "d", "dice faces out of range")
+	}
+
+	if n.explode != nil || n.reroll != nil {
+		return e.evalModified(n, times, sides, timesV.shape.asScalar(), sidesRange)
+	}
+
+	rolls := make([]int, 0, times)
+	dice := make([]Die, 0, times)
+	sum := 0
+	for i := 0; i < times; i++ {
+		if err := e.tick(n, i); err != nil {
+			return Value{}, err
+		}
+		rnum := e.intn(sides) + 1
+		rolls = append(rolls, rnum)
+		dice = append(dice, newDie("d", sides, rnum))
+		sum += rnum
+	}
+
+	return Value{V: sum, Meta: rolls, MetaEnable: true, dice: dice, shape: diceShape(timesV.shape.asScalar(), sidesRange)}, nil
+}
+
+// maxExplode 单颗骰子最多连续爆炸的次数，防止爆炸无限递归
+const maxExplode = 100
+
+// maxReroll r 修饰下单次掷骰最多重投的次数
+const maxReroll = 100
+
+// evalModified 掷出带修饰的骰子：每次掷骰先按重投修饰重投，再按爆炸修饰追加
+//   - r<cond>：点数满足条件时重投，直到不满足为止，最多重投 maxReroll 次
+//   - ro<cond>：点数满足条件时只重投一次
+//   - !：触发时追加一颗新骰子，新骰子同样可以爆炸
+//   - !!：触发时再掷一次并累加到同一颗骰子上
+//   - !p：与 ! 相同，但追加的骰子结果减 1
+//
+// 爆炸未指定比较点时在掷出最大面时触发；每颗骰子最多连续爆炸 maxExplode 次
+// 复利爆炸只记录第一次掷骰的重投过程
+func (e *evaluator) evalModified(n *diceNode, times, sides int, timesRange, sidesRange interval) (Value, *Error) {
+	roll := func() (int, []int) { return e.intn(sides) + 1, nil }
+	if mod := n.reroll; mod != nil {
+		cv, err := e.eval(mod.cmp.value)
+		if err != nil {
+			return Value{}, err
+		}
+		match := func(r int) bool { return compare(r, mod.cmp.op, cv.V) }
+		limit := maxReroll
+		if mod.once {
+			limit = 1
+		} else if matchesEveryFace(sides, match) {
+			return Value{}, e.fail(ErrInputChildParaInvalid, mod, "r", "reroll condition matches every face")
+		}
+		roll = func() (int, []int) {
+			r := e.intn(sides) + 1
+			var history []int
+			for k := 0; k < limit && match(r); k++ {
+				history = append(history, r)
+				r = e.intn(sides) + 1
+			}
+			return r, history
+		}
+	}
+
+	kind := ""
+	trigger := func(int) bool { return false }
+	sh := diceShape(timesRange, sidesRange)
+	if mod := n.explode; mod != nil {
+		cmpOp, cmpV := "=", sides
+		cmpRange := pointInterval(sides)
+		if mod.cmp != nil {
+			v, err := e.eval(mod.cmp.value)
+			if err != nil {
+				return Value{}, err
+			}
+			cmpOp, cmpV = mod.cmp.op, v.V
+			cmpRange = v.shape.total
+		}
+		kind = mod.kind
+		trigger = func(r int) bool { return compare(r, cmpOp, cmpV) }
+		if matchesEveryFace(sides, trigger) {
+			return Value{}, e.fail(ErrInputChildParaInvalid, mod, mod.kind, "explosion condition matches every face")
+		}
+		sh = explodeShape(mod.kind, timesRange, sidesRange, canExplode(sidesRange, cmpOp, cmpRange, mod.cmp != nil))
+	}
+
+	meta := make([]int, 0, times)
+	dice := make([]Die, 0, times)
+	sum := 0
+	for i := 0; i < times; i++ {
+		if err := e.tick(n, i); err != nil {
+			return Value{}, err
+		}
+		r, history := roll()
+		switch kind {
+		case "!!":
+			d := newDie("d", sides, r)
+			d.Rerolls, d.Parts = history, []int{r}
+			for k := 0; k < maxExplode && trigger(r); k++ {
+				r, _ = roll()
+				d.Parts = append(d.Parts, r)
+				d.Exploded = true
+				d.Value += r
+			}
+			meta = append(meta, d.Value)
+			dice = append(dice, d)
+			sum += d.Value
+		default:
+			v := r
+			for k := 0; ; k++ {
+				fire := k < maxExplode && trigger(r)
+				d := newDie("d", sides, r)
+				d.Value, d.Rerolls, d.Exploded = v, history, fire
+				meta = append(meta, v)
+				dice = append(dice, d)
+				sum += v
+				if !fire {
+					break
+				}
+				r, history = roll()
+				v = r
+				if kind == "!p" {
+					v--
+				}
+			}
+		}
+	}
+
+	return Value{V: sum, Meta: meta, MetaEnable: true, dice: dice, shape: sh}, nil
+}
+
+// matchesEveryFace 判断条件是否对 1..sides 的每个面都成立
+func matchesEveryFace(sides int, match func(int) bool) bool {
+	for f := 1; f <= sides; f++ {
+		if !match(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// compare 按比较点的运算符比较 a 与 b
+func compare(a int, op string, b int) bool {
+	switch op {
+	case ">":
+		return a > b
+	case "<":
+		return a < b
+	case ">=":
+		return a >= b
+	case "<=":
+		return a <= b
+	}
+	return a == b
+}
+
+// evalChain 附加链 a 与压缩链 c
+//   - a：掷 times 颗骰子，每颗大于等于 threshold 的骰子计一次成功并在下一轮追加一颗
+//   - c：每轮取最大值累加，只要本轮有骰子大于等于 threshold 就继续
+func (e *evaluator) evalChain(n *chainNode) (Value, *Error) {
+	leftV, err := e.eval(n.times)
+	if err != nil {
+		return Value{}, err
+	}
+	rightV, err := e.eval(n.threshold)
+	if err != nil {
+		return Value{}, err
+	}
+	m := 10 // 默认面数
+	facesRange := pointInterval(m)
+	if n.faces != nil {
+		facesV, err := e.eval(n.faces)
+		if err != nil {
+			return Value{}, err
+		}
+		m = facesV.V
+		facesRange = facesV.shape.total
+	}
+
+	times := leftV.V
+	threshold := rightV.V
+	if times < 0 || times > 10000 {
+		return Value{}, e.fail(ErrNodeExtremeValInvalid, n.times, n.op, "dice count out of range")
+	}
+	if threshold <= 0 || threshold > 10000 {
+		return Value{}, e.fail(ErrNodeExtremeValInvalid, n.threshold, n.op, "threshold out of range")
+	}
+	if m <= 0 || m > 10000 {
+		return Value{}, e.fail(ErrNodeExtremeValInvalid, n.faces, n.op, "dice faces out of range")
+	}
+
+	total := 0
+	meta := []int{}
+	var dice []Die
+	next := times
+
+	for next > 0 {
+		cur := next
+		next = 0
+		maxv := 0
+		for i := 0; i < cur; i++ {
+			if err := e.tick(n, len(meta)); err != nil {
+				return Value{}, err
+			}
+			rnum := e.intn(m) + 1
+			meta = append(meta, rnum)
+			d := newDie(n.op, m, rnum)
+			d.Exploded = rnum >= threshold
+			dice = append(dice, d)
+			if rnum > maxv {
+				maxv = rnum
+			}
+			if rnum >= threshold {
+				next++
+				if n.op == "a" {
+					total++
+				}
+			}
+		}
+		if n.op == "c" {
+			total += maxv
+		}
+		// 阈值不大于 1 时链条永不停止，由 MaxDice 与 MaxTupleLen 终止
+		if exceeds(e.budget.dice, e.lim.MaxDice) {
+			return Value{}, e.fail(ErrLimitExceeded, n, n.op, "too many dice rolled")
+		}
+		if exceeds(len(meta), e.lim.MaxTupleLen) {
+			return Value{}, e.fail(ErrLimitExceeded, n, n.op, "tuple too long")
+		}
+	}
+
+	sh := chainShape(n.op, leftV.shape.total, rightV.shape.total, facesRange)
+	return Value{V: total, Meta: meta, MetaEnable: len(meta) > 0, dice: dice, shape: sh}, nil
+}
+
+// evalBinary 对二元运算求值，先求左侧再求右侧，并推导结果的取值范围
+func (e *evaluator) evalBinary(n *binaryNode) (Value, *Error) {
+	var a Value
+	var err *Error
+	if t, ok := assignTarget(n); ok {
+		// 赋值目标不需要事先赋值，即使启用了 strictVars
+		a = e.evalTemp(t)
+	} else if a, err = e.eval(n.left); err != nil {
+		return Value{}, err
+	}
+	b, err := e.eval(n.right)
+	if err != nil {
+		return Value{}, err
+	}
+	v, err := e.applyBinary(n, a, b)
+	if err != nil {
+		return Value{}, err
+	}
+	v.shape = binaryShape(n.op, a.shape, b.shape)
+	if isScalarOp(n.op) {
+		v.dice = joinDice(a, b)
+	}
+	if n.op == "=" {
+		if e.shapes == nil {
+			e.shapes = map[int]shape{}
+		}
+		e.shapes[a.TempIndex] = v.shape
+	}
+	return v, nil
+}
+
+// assignTarget 若 n 是对临时变量（可以带括号）的赋值，返回该临时变量
+func assignTarget(n *binaryNode) (*tempNode, bool) {
+	if n.op != "=" {
+		return nil, false
+	}
+	x := n.left
+	for {
+		g, ok := x.(*groupNode)
+		if !ok {
+			break
+		}
+		x = g.x
+	}
+	t, ok := x.(*tempNode)
+	return t, ok
+}
+
+// applyBinary 对已求值的两个操作数执行二元运算
+func (e *evaluator) applyBinary(n *binaryNode, a, b Value) (Value, *Error) {
+	leftErr := func(msg string) *Error {
+		return e.fail(ErrNodeLeftValInvalid, n.left, n.op, msg)
+	}
+	rightErr := func(msg string) *Error {
+		return e.fail(ErrNodeRightValInvalid, n.right, n.op, msg)
+	}
+	// rangeErr 操作数超出允许的范围，tupleErr 操作数不是多元组或下标越界
+	rangeErr := func(side node, msg string) *Error {
+		return e.fail(ErrNodeExtremeValInvalid, side, n.op, msg)
+	}
+	tupleErr := func(side node, msg string) *Error {
+		return e.fail(ErrNodeSubValInvalid, side, n.op, msg)
+	}
+
+	switch n.op {
+	case "+":
+		return Value{V: a.V + b.V}, nil
+	case "-":
+		return Value{V: a.V - b.V}, nil
+	case "*":
+		return Value{V: a.V * b.V}, nil
+	case "/":
+		if b.V == 0 {
+			return Value{}, rightErr("division by zero")
+		}
+		return Value{V: a.V / b.V}, nil
+	case ">": // 大于比较
+		return boolValue(a.V > b.V), nil
+	case "<": // 小于比较
+		return boolValue(a.V < b.V), nil
+	case ">=":
+		return boolValue(a.V >= b.V), nil
+	case "<=":
+		return boolValue(a.V <= b.V), nil
+	case "==":
+		return boolValue(a.V == b.V), nil
+	case "!=":
+		return boolValue(a.V != b.V), nil
+	case "&": // 按位与
+		return Value{V: a.V & b.V}, nil
+	case "|": // 按位或
+		return Value{V: a.V | b.V}, nil
+	case "^":
+		if a.V == 0 && b.V == 0 {
+			return Value{}, leftErr("zero to the power of zero")
+		}
+		if b.V < 0 {
+			return Value{}, rightErr("negative exponent")
+		}
+		if err := e.spend(n, b.V); err != nil {
+			return Value{}, err
+		}
+		res := 1
+		for i := 0; i < b.V; i++ {
+			if err := e.tick(n, i); err != nil {
+				return Value{}, err
+			}
+			res *= a.V
+		}
+		return Value{V: res}, nil
+	case "=": // 赋值：左侧必须是临时变量
+		if !a.IsTemp {
+			return Value{}, leftErr("assignment target is not a temp variable")
+		}
+		if e.temp == nil {
+			e.temp = map[int]int{}
+		}
+		e.temp[a.TempIndex] = b.V
+
+		if e.vt == nil {
+			e.vt = map[string]int{}
+		}
+		e.vt[fmt.Sprintf("T%d", a.TempIndex)] = b.V
+		return Value{V: b.V, dice: b.dice}, nil
+	case "k", "q": // 保留最高 k 个 / 最低 q 个
+		if b.V <= 0 {
+			return Value{}, rightErr("count must be positive")
+		}
+		rolls, ok, err := e.resolveMetaValues(a)
+		if err != nil {
+			return Value{}, err
+		}
+		if !ok {
+			return Value{}, tupleErr(n.left, "operand is not a tuple")
+		}
+		mode := "kh"
+		if n.op == "q" {
+			mode = "kl"
+		}
+		return e.keepValue(a, rolls, selectIndices(rolls, b.V, mode)), nil
+	case "kh", "kl", "dh", "dl":
+		if b.V <= 0 {
+			return Value{}, rightErr("count must be positive")
+		}
+		rolls, ok, err := e.resolveMetaValues(a)
+		if err != nil {
+			return Value{}, err
+		}
+		if !ok || len(rolls) == 0 {
+			return Value{}, tupleErr(n.left, "operand is not a tuple")
+		}
+		return e.keepValue(a, rolls, selectIndices(rolls, b.V, n.op)), nil
+	case "min", "max": // 将每个元素限制在下限/上限
+		if b.V <= 0 {
+			return Value{}, rightErr("bound must be positive")
+		}
+		rolls := a.Meta
+		if !a.MetaEnable {
+			rolls = []int{a.V}
+		}
+		resList := make([]int, len(rolls))
+		dice := append([]Die(nil), a.dice...)
+		kept := keptDice(a.dice, rolls)
+		sum := 0
+		for i, rv := range rolls {
+			if n.op == "max" {
+				if rv > b.V {
+					rv = b.V
+				}
+			} else if rv < b.V {
+				rv = b.V
+			}
+			resList[i] = rv
+			if kept != nil {
+				dice[kept[i]].Value = rv
+			}
+			sum += rv
+		}
+		return Value{V: sum, Meta: resList, MetaEnable: true, dice: dice}, nil
+	case "b", "p":
+		return e.evalBonus(n, a, b)
+	case "f": // fudge/fate 骰子：左侧次数掷出 [-1,1] 并求和
+		if b.V <= 1 || b.V > 10000 {
+			return Value{}, rangeErr(n.right, "fudge faces out of range")
+		}
+		if a.V <= 0 || a.V > 10000 {
+			return Value{}, rangeErr(n.left, "dice count out of range")
+		}
+		rolls := make([]int, 0, a.V)
+		dice := make([]Die, 0, a.V)
+		sum := 0
+		for i := 0; i < a.V; i++ {
+			rnum := e.intn(3) - 1
+			rolls = append(rolls, rnum)
+			dice = append(dice, Die{Value: rnum, Faces: 3, Op: "f", Critical: rnum == 1, Fumble: rnum == -1})
+			sum += rnum
+		}
+		return Value{V: sum, Meta: rolls, MetaEnable: true, dice: dice}, nil
+	case "sp": // 选择位置：返回指定位置的单个元素
+		if !a.MetaEnable {
+			if b.V == 1 || b.V == -1 {
+				return Value{V: a.V, Meta: []int{a.V}, MetaEnable: true, dice: a.dice}, nil
+			}
+			return Value{}, tupleErr(n.left, "operand is not a tuple")
+		}
+		pos, ok := position(len(a.Meta), b.V)
+		if !ok {
+			return Value{}, tupleErr(n.right, "position out of range")
+		}
+		v := a.Meta[pos]
+		return Value{V: v, Meta: []int{v}, MetaEnable: true, dice: dropDice(a, []int{pos})}, nil
+	case "tp": // 取得位置：移除指定位置的元素并返回剩余元素的总和
+		if !a.MetaEnable {
+			if b.V == 1 || b.V == -1 {
+				return Value{V: 0, Meta: []int{}, MetaEnable: false, dice: dropDice(a, nil)}, nil
+			}
+			return Value{}, tupleErr(n.left, "operand is not a tuple")
+		}
+		pos, ok := position(len(a.Meta), b.V)
+		if !ok {
+			return Value{}, tupleErr(n.right, "position out of range")
+		}
+		newList := append([]int{}, a.Meta[:pos]...)
+		newList = append(newList, a.Meta[pos+1:]...)
+		keep := make([]int, 0, len(newList))
+		sum := 0
+		for i, vv := range a.Meta {
+			if i != pos {
+				keep = append(keep, i)
+				sum += vv
+			}
+		}
+		return Value{V: sum, Meta: newList, MetaEnable: len(newList) > 0, dice: dropDice(a, keep)}, nil
+	case "lp": // 重复/循环：左侧元数据列表重复右侧次数
+		return e.evalLoop(n, a, b)
+	}
+	return Value{}, e.fail(ErrNodeOperationInvalid, n, n.op, "unknown operator")
+}
+
+// keepValue 保留 rolls 中下标为 idx 的元素，其余骰子标记为丢弃
+// 保留的元素默认按原有顺序排列，sortedKeep 时按 idx 的排序顺序排列
+func (e *evaluator) keepValue(a Value, rolls []int, idx []int) Value {
+	if !e.sortedKeep {
+		sort.Ints(idx)
+	}
+	sel := make([]int, len(idx))
+	sum := 0
+	for i, k := range idx {
+		sel[i] = rolls[k]
+		sum += rolls[k]
+	}
+	return Value{V: sum, Meta: sel, MetaEnable: len(sel) > 0, dice: dropDice(a, idx)}
+}
+
+// boolValue 将布尔值转换为 0/1
+func boolValue(b bool) Value {
+	if b {
+		return Value{V: 1}
+	}
+	return Value{V: 0}
+}
+
+// position 将 1 起始（负数表示从末尾计数）的位置转换为切片下标
+func position(n, idx int) (int, bool) {
+	if idx == 0 {
+		return 0, false
+	}
+	pos := idx - 1
+	if idx < 0 {
+		pos = n + idx
+	}
+	if pos < 0 || pos >= n {
+		return 0, false
+	}
+	return pos, true
+}
+
+// evalBonus 奖励骰 b 与惩罚骰 p（COC）：将 d100 视为十位和个位两颗 d10（0..9）
+// 再投掷 param 颗额外的十位骰，奖励骰取最小值、惩罚骰取最大值替换十位
+// 若十位和个位都是 0，结果为 100
+func (e *evaluator) evalBonus(n *binaryNode, left, param Value) (Value, *Error) {
+	if param.V < 0 || param.V > 10000 {
+		return Value{}, e.fail(ErrNodeExtremeValInvalid, n.right, n.op, "bonus dice count out of range")
+	}
+	if left.V > 10000 {
+		return Value{}, e.fail(ErrNodeExtremeValInvalid, n.left, n.op, "operand out of range")
+	}
+
+	tens := e.intn(10)
+	units := e.intn(10)
+	rolls := make([]int, 0, param.V)
+	for i := 0; i < param.V; i++ {
+		rolls = append(rolls, e.intn(10))
+	}
+
+	// 骰子按掷出顺序排列：十位、个位、额外的十位骰；未被采用的十位骰标记为丢弃
+	dice := make([]Die, 0, 2+len(rolls))
+	dice = append(dice, Die{Value: tens, Faces: 10, Op: n.op}, Die{Value: units, Faces: 10, Op: n.op})
+	for _, v := range rolls {
+		dice = append(dice, Die{Value: v, Faces: 10, Op: n.op, Dropped: true})
+	}
+
+	var out int
+	if tens == 0 && units == 0 {
+		out = 100
+	} else {
+		if len(rolls) > 0 {
+			sel := 0
+			for i, v := range rolls[1:] {
+				if (n.op == "b" && v < rolls[sel]) || (n.op == "p" && v > rolls[sel]) {
+					sel = i + 1
+				}
+			}
+			tens = rolls[sel]
+			dice[0].Dropped = true
+			dice[2+sel].Dropped = false
+		}
+		out = tens*10 + units
+	}
+
+	meta := make([]int, 0, 2+len(rolls))
+	meta = append(meta, tens, units)
+	meta = append(meta, rolls...)
+	return Value{V: out, Meta: meta, MetaEnable: true, dice: dice}, nil
+}
+
+// evalLoop 重复运算 lp：字符串模板中的 {i} 会被替换为从 1 开始的序号
+func (e *evaluator) evalLoop(n *binaryNode, left, param Value) (Value, *Error) {
+	times := param.V
+	if times <= 0 {
+		return Value{}, e.fail(ErrNodeRightValInvalid, n.right, n.op, "count must be positive")
+	}
+
+	if err := e.checkRepeat(n, len(left.MetaStr)+len(left.Meta), times); err != nil {
+		return Value{}, err
+	}
+
+	if len(left.MetaStr) > 0 {
+		outList := make([]string, 0, len(left.MetaStr)*times)
+		idx := 1
+		size := 0
+		for t := 0; t < times; t++ {
+			if err := e.tick(n, t); err != nil {
+				return Value{}, err
+			}
+			for _, tmpl := range left.MetaStr {
+				s := strings.ReplaceAll(tmpl, "{i}", strconv.Itoa(idx))
+				if size += len(s); exceeds(size, e.lim.MaxStringOutput) {
+					return Value{}, e.fail(ErrLimitExceeded, n, n.op, "string output too long")
+				}
+				outList = append(outList, s)
+				idx++
+			}
+		}
+		return Value{V: 0, MetaEnable: true, MetaStr: outList}, nil
+	}
+
+	rolls, ok, err := e.resolveMetaValues(left)
+	if err != nil {
+		return Value{}, err
+	}
+	if !ok {
+		return Value{}, e.fail(ErrNodeSubValInvalid, n.left, n.op, "operand is not a tuple")
+	}
+	newList := make([]int, 0, len(rolls)*times)
+	var dice []Die
+	for i := 0; i < times; i++ {
+		newList = append(newList, rolls...)
+		dice = append(dice, left.dice...)
+	}
+	sum := 0
+	for _, vv := range newList {
+		sum += vv
+	}
+	return Value{V: sum, Meta: newList, MetaEnable: len(newList) > 0, dice: dice}, nil
+}
+
+// checkRepeat 在展开前检查 lp 将 size 个元素重复 times 次后是否超出 MaxTupleLen
+func (e *evaluator) checkRepeat(n *binaryNode, size, times int) *Error {
+	if size == 0 {
+		size = 1
+	}
+	if e.lim.MaxTupleLen >= 0 && times > e.lim.MaxTupleLen/size {
+		return e.fail(ErrLimitExceeded, n, n.op, "tuple too long")
+	}
+	return nil
+}
+
+// resolveMetaValues 将可能包含 Meta 或 MetaStr 的 Value 转换为整数切片
+// MetaStr 中的每个元素会作为子表达式求值；成功时返回解析的切片和 true
+// 子表达式超出资源限制时返回该错误，其余求值失败视为无法转换
+func (e *evaluator) resolveMetaValues(v Value) ([]int, bool, *Error) {
+	if !v.MetaEnable {
+		return []int{v.V}, true, nil
+	}
+
+	if v.Meta != nil {
+		return append([]int(nil), v.Meta...), true, nil
+	}
+
+	if len(v.MetaStr) > 0 {
+		res := make([]int, 0, len(v.MetaStr))
+		for _, s := range v.MetaStr {
+			sv, err := e.evalString(s)
+			if err != nil {
+				// 超出限制与取消需要原样返回，其余错误表示该元素不能作为数值
+				if err.Code == ErrLimitExceeded || err.Code == ErrCancelled {
+					return nil, false, err
+				}
+				return nil, false, nil
+			}
+			res = append(res, sv)
+		}
+		return res, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// evalString 将字符串作为子表达式求值
+// 子表达式使用独立的临时变量表，但与调用者共享随机数生成器与 ValueTable
+func (e *evaluator) evalString(s string) (int, *Error) {
+	root, err := parse(s, e.lim, e.normalize)
+	if err != nil {
+		return 0, err
+	}
+	sub := &evaluator{rng: e.rng, lim: e.lim, budget: e.budget, ctx: e.ctx, record: e.record, vt: e.vt, temp: map[int]int{}, defaultFaces: e.defaultFaces, sortedKeep: e.sortedKeep, strictVars: e.strictVars, normalize: e.normalize, src: s}
+	v, err := sub.eval(root)
+	if err != nil {
+		return 0, err
+	}
+	if e.vt == nil {
+		e.vt = sub.vt
+	}
+	return v.V, nil
+}
@@ -0,0 +1,202 @@
+package gonedice
+
+import (
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// FairSession 是一次可验证的掷骰会话（commit-reveal）
+// 会话开始时生成服务端种子并公开其哈希承诺 Commitment，之后玩家才提供客户端种子（SetClientSeed），
+// 因此服务端无法针对客户端种子挑选服务端种子。每次掷骰的随机数由
+// HMAC-SHA256(serverSeed, clientSeed:nonce:counter) 生成，会话结束后用 Reveal 公开种子，
+// 任何人都可以用 VerifyFair 在本地重新求值，核对每一次掷骰
+type FairSession struct {
+	mu sync.Mutex
+	// serverSeed 服务端种子，Reveal 之前保密
+	serverSeed []byte
+	// committed 承诺是否已经通过 Commitment 取得
+	committed bool
+	// clientSeed 客户端种子，由玩家在承诺公开之后提供，防止服务端预先挑选种子
+	clientSeed string
+	// nonce 下一次掷骰使用的序号
+	nonce uint64
+	// revealed 种子是否已经公开
+	revealed bool
+}
+
+// FairRoll 是会话中的一次掷骰，与公开的种子一起即可验证
+type FairRoll struct {
+	// Expr 掷骰表达式
+	Expr string
+	// ClientSeed 客户端种子
+	ClientSeed string
+	// Nonce 本次掷骰的序号，从 0 开始
+	Nonce uint64
+	// Result 掷骰结果
+	Result Result
+}
+
+// NewFairSession 使用 crypto/rand 生成 32 字节的服务端种子并开始会话
+// 之后应先公开 Commitment，再用 SetClientSeed 接受玩家提供的客户端种子
+func NewFairSession() (*FairSession, error) {
+	seed := make([]byte, 32)
+	if _, err := crand.Read(seed); err != nil {
+		return nil, err
+	}
+	return &FairSession{serverSeed: seed}, nil
+}
+
+// Commitment 返回服务端种子的 SHA-256 承诺（十六进制），应在接受客户端种子之前公开
+func (s *FairSession) Commitment() string {
+	s.mu.Lock()
+	s.committed = true
+	s.mu.Unlock()
+	sum := sha256.Sum256(s.serverSeed)
+	return hex.EncodeToString(sum[:])
+}
+
+// SetClientSeed 设置玩家提供的客户端种子
+// 必须在 Commitment 之后、第一次掷骰之前调用，否则返回 ErrClientSeedInvalid；
+// 第一次掷骰之前可以重复设置，以最后一次为准
+func (s *FairSession) SetClientSeed(clientSeed string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	invalid := func(code ErrorType, msg string) error {
+		return newError(code, clientSeed, 0, len(clientSeed), "", msg)
+	}
+	switch {
+	case s.revealed:
+		return invalid(ErrSessionRevealed, "server seed has been revealed")
+	case !s.committed:
+		return invalid(ErrClientSeedInvalid, "commitment has not been published")
+	case s.nonce > 0:
+		return invalid(ErrClientSeedInvalid, "client seed cannot change after the first roll")
+	case clientSeed == "":
+		return invalid(ErrClientSeedInvalid, "client seed is empty")
+	}
+	s.clientSeed = clientSeed
+	return nil
+}
+
+// Roll 使用下一个 nonce 求值表达式，opts 中的随机数来源会被忽略
+// 设置客户端种子之前掷骰返回 ErrClientSeedInvalid；种子公开后会话结束，之后的掷骰返回 ErrSessionRevealed
+func (s *FairSession) Roll(expr string, opts ...Option) FairRoll {
+	return s.RollContext(context.Background(), expr, opts...)
+}
+
+// RollContext 与 Roll 相同，但 ctx 被取消或超时后求值会尽快中止并返回 ErrCancelled
+func (s *FairSession) RollContext(ctx context.Context, expr string, opts ...Option) FairRoll {
+	s.mu.Lock()
+	roll := FairRoll{Expr: expr, ClientSeed: s.clientSeed, Nonce: s.nonce}
+	var err *Error
+	if s.revealed {
+		err = newError(ErrSessionRevealed, expr, 0, len(expr), "", "server seed has been revealed")
+	} else if s.clientSeed == "" {
+		err = newError(ErrClientSeedInvalid, expr, 0, len(expr), "", "client seed has not been set")
+	}
+	if err != nil {
+		s.mu.Unlock()
+		err.localize(newConfig(opts).locale)
+		roll.Result = Result{Error: err.Code, Err: err}
+		return roll
+	}
+	s.nonce++
+	s.mu.Unlock()
+
+	roll.Result = fairEval(ctx, s.serverSeed, roll, opts)
+	return roll
+}
+
+// Reveal 公开服务端种子（十六进制）并结束会话
+func (s *FairSession) Reveal() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revealed = true
+	return hex.EncodeToString(s.serverSeed)
+}
+
+// VerifyFair 核对一次掷骰：serverSeed 的哈希必须等于 commitment，
+// 且用 serverSeed、roll.ClientSeed 与 roll.Nonce 重新求值得到相同的结果
+// opts 应与原始掷骰一致（变量表、默认面数等）；不一致时返回 ErrFairMismatch
+func VerifyFair(commitment, serverSeed string, roll FairRoll, opts ...Option) error {
+	mismatch := func(format string, args ...any) error {
+		return newError(ErrFairMismatch, roll.Expr, 0, len(roll.Expr), "", format, args...).localize(newConfig(opts).locale)
+	}
+	seed, err := hex.DecodeString(serverSeed)
+	if err != nil {
+		return mismatch("server seed is not hex: %v", err)
+	}
+	sum := sha256.Sum256(seed)
+	if !hmac.Equal([]byte(hex.EncodeToString(sum[:])), []byte(commitment)) {
+		return mismatch("server seed does not match the commitment")
+	}
+	res := fairEval(context.Background(), seed, roll, opts)
+	if res.Error != roll.Result.Error || res.Value != roll.Result.Value || fmt.Sprint(res.MetaTuple) != fmt.Sprint(roll.Result.MetaTuple) {
+		return mismatch("nonce %d replayed to %d, recorded %d", roll.Nonce, res.Value, roll.Result.Value)
+	}
+	return nil
+}
+
+// NewFairSource 返回由 HMAC-SHA256(serverSeed, clientSeed:nonce:counter) 生成的随机数来源
+// counter 从 0 开始，每个 32 字节的摘要依次切分为 4 个大端 uint64，
+// Intn 对 uint64 拒绝采样后取模，因此结果没有取模偏差，且可以用其他语言复现
+func NewFairSource(serverSeed []byte, clientSeed string, nonce uint64) Source {
+	return &fairSource{key: serverSeed, id: clientSeed + ":" + strconv.FormatUint(nonce, 10)}
+}
+
+// fairEval 使用 roll 的客户端种子与 nonce 求值表达式
+func fairEval(ctx context.Context, serverSeed []byte, roll FairRoll, opts []Option) Result {
+	p, err := Compile(roll.Expr, opts...)
+	if err != nil {
+		e := err.(*Error)
+		return Result{Error: e.Code, Err: e}
+	}
+	src := NewFairSource(serverSeed, roll.ClientSeed, roll.Nonce)
+	return p.RollContext(ctx, append(append([]Option(nil), opts...), WithRNG(src))...)
+}
+
+// fairSource 是 HMAC-SHA256 计数器模式的随机数流
+type fairSource struct {
+	key []byte
+	// id 为 clientSeed:nonce，HMAC 的消息为 id:counter，counter 以十进制书写
+	id      string
+	counter uint64
+	// buf 当前摘要中尚未使用的字节
+	buf []byte
+}
+
+// next 返回流中的下一个 uint64
+func (s *fairSource) next() uint64 {
+	if len(s.buf) == 0 {
+		mac := hmac.New(sha256.New, s.key)
+		mac.Write([]byte(s.id + ":" + strconv.FormatUint(s.counter, 10)))
+		s.buf = mac.Sum(nil)
+		s.counter++
+	}
+	v := binary.BigEndian.Uint64(s.buf)
+	s.buf = s.buf[8:]
+	return v
+}
+
+// Intn 实现 Source：丢弃小于 2^64 mod n 的值，使剩余的取值数量是 n 的整数倍
+func (s *fairSource) Intn(n int) int {
+	un := uint64(n)
+	thresh := -un % un
+	for {
+		if v := s.next(); v >= thresh {
+			return int(v % un)
+		}
+	}
+}
+
+// describe 实现 describer，服务端种子在公开前保密，因此只报告客户端种子与 nonce
+func (s *fairSource) describe() (string, string) {
+	return "hmac-sha256", s.id
+}
@@ -0,0 +1,146 @@
+package gonedice
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// newFairSession starts a session, publishes its commitment and sets the client seed
+func newFairSession(t *testing.T, clientSeed string) (*FairSession, string) {
+	t.Helper()
+	s, err := NewFairSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitment := s.Commitment()
+	if err := s.SetClientSeed(clientSeed); err != nil {
+		t.Fatal(err)
+	}
+	return s, commitment
+}
+
+func TestFairSession(t *testing.T) {
+	s, commitment := newFairSession(t, "player-seed")
+	vt := map[string]int{"STR": 2}
+	var rolls []FairRoll
+	for i := 0; i < 5; i++ {
+		roll := s.Roll("4d6kh3+1d20+{STR}", WithValueTable(vt))
+		if roll.Result.Error != "" || roll.Nonce != uint64(i) {
+			t.Fatalf("unexpected roll %+v", roll)
+		}
+		rolls = append(rolls, roll)
+	}
+	seed := s.Reveal()
+	if roll := s.Roll("1d6"); roll.Result.Error != ErrSessionRevealed {
+		t.Fatalf("rolling after reveal should fail: %v", roll.Result.Error)
+	}
+	for _, roll := range rolls {
+		if err := VerifyFair(commitment, seed, roll, WithValueTable(vt)); err != nil {
+			t.Fatalf("nonce %d: %v", roll.Nonce, err)
+		}
+	}
+
+	tampered := rolls[0]
+	tampered.Result.Value++
+	other, _ := newFairSession(t, "player-seed")
+	for _, c := range []struct {
+		commitment, seed string
+		roll             FairRoll
+	}{
+		{commitment, seed, tampered},
+		{commitment, other.Reveal(), rolls[0]},
+		{commitment, "zz", rolls[0]},
+		{other.Commitment(), seed, rolls[0]},
+	} {
+		var e *Error
+		if err := VerifyFair(c.commitment, c.seed, c.roll, WithValueTable(vt)); !errors.As(err, &e) || e.Code != ErrFairMismatch {
+			t.Fatalf("expected mismatch got %v", err)
+		}
+	}
+}
+
+func TestFairSessionClientSeed(t *testing.T) {
+	s, err := NewFairSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the client seed is only accepted once the commitment is published
+	if err := s.SetClientSeed("early"); !errors.Is(err, ErrClientSeedInvalid) {
+		t.Fatalf("client seed before the commitment should be rejected: %v", err)
+	}
+	if roll := s.Roll("1d6"); roll.Result.Error != ErrClientSeedInvalid {
+		t.Fatalf("rolling without a client seed should fail: %v", roll.Result.Error)
+	}
+	commitment := s.Commitment()
+	if err := s.SetClientSeed(""); !errors.Is(err, ErrClientSeedInvalid) {
+		t.Fatalf("empty client seed should be rejected: %v", err)
+	}
+	if err := s.SetClientSeed("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetClientSeed("second"); err != nil {
+		t.Fatalf("the client seed may change before the first roll: %v", err)
+	}
+	roll := s.Roll("1d20")
+	if roll.Result.Error != "" || roll.ClientSeed != "second" || roll.Nonce != 0 {
+		t.Fatalf("unexpected roll %+v", roll)
+	}
+	// once rolling has started the client seed is fixed
+	if err := s.SetClientSeed("third"); !errors.Is(err, ErrClientSeedInvalid) {
+		t.Fatalf("changing the client seed after rolling should be rejected: %v", err)
+	}
+	if next := s.Roll("1d20"); next.ClientSeed != "second" || next.Nonce != 1 {
+		t.Fatalf("unexpected roll %+v", next)
+	}
+	seed := s.Reveal()
+	if err := s.SetClientSeed("fourth"); !errors.Is(err, ErrSessionRevealed) {
+		t.Fatalf("setting the client seed after reveal should fail: %v", err)
+	}
+	if err := VerifyFair(commitment, seed, roll); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFairSourceStream(t *testing.T) {
+	key := []byte("server")
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("client:7:0"))
+	sum := mac.Sum(nil)
+	src := NewFairSource(key, "client", 7)
+	for i := 0; i < 4; i++ {
+		want := int(binary.BigEndian.Uint64(sum[i*8:]) % 1000)
+		if got := src.Intn(1000); got != want {
+			t.Fatalf("draw %d: expected %d got %d", i, want, got)
+		}
+	}
+	if a, b := NewFairSource(key, "client", 7), NewFairSource(key, "client", 8); a.Intn(1<<30) == b.Intn(1<<30) {
+		t.Fatalf("different nonces should give different streams")
+	}
+}
+
+func TestFairSessionConcurrent(t *testing.T) {
+	s, _ := newFairSession(t, "c")
+	var wg sync.WaitGroup
+	seen := make([]bool, 64)
+	var mu sync.Mutex
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			roll := s.Roll("1d100")
+			mu.Lock()
+			seen[roll.Nonce] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	for i, ok := range seen {
+		if !ok {
+			t.Fatalf("nonce %d was not used", i)
+		}
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/Sheyiyuan/gonedice
 
-go 1.21.1
+go 1.22
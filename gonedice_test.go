@@ -717,3 +717,569 @@ func TestLpStringTemplateComplex(t *testing.T) {
 		t.Fatalf("lp string complex content mismatch: %v", res.MetaTuple)
 	}
 }
+
+func TestExplodingDice(t *testing.T) {
+	r := New("3d6!>=5", nil)
+	r.rng = rand.New(rand.NewSource(5))
+	r.Roll()
+	res := r.Result()
+	if res.Error != "" {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	// seed 5 rolls 1,5,2 and the two 5s explode into 5 and 4
+	if res.Value != 17 || len(res.MetaTuple) != 5 {
+		t.Fatalf("expected 17 with 5 dice got %d %v", res.Value, res.MetaTuple)
+	}
+	if res.Detail != "17 [1,5!,2,5!,4] min=3 max=∞" {
+		t.Fatalf("unexpected detail %q", res.Detail)
+	}
+
+	// a number right after ! is an =N trigger, while != is always an inequality
+	for _, c := range []struct {
+		expr string
+		want int
+	}{{"3d6!5", 17}, {"3d6! =5", 17}, {"3d6!=5", 1}, {"3d6 != 5", 1}, {"3d6!!=8", 0}} {
+		res := MustCompile(c.expr).Roll(WithRNG(rand.New(rand.NewSource(5))))
+		if res.Error != "" || res.Value != c.want {
+			t.Fatalf("%q: expected %d got %d (%v)", c.expr, c.want, res.Value, res.Err)
+		}
+	}
+
+	// every explosion adds a die, and the sum always matches the metadata
+	rng := rand.New(rand.NewSource(9))
+	for i := 0; i < 200; i++ {
+		r := New("2d4!", nil)
+		r.rng = rng
+		r.Roll()
+		res := r.Result()
+		sum, maxes := 0, 0
+		for _, m := range res.MetaTuple {
+			sum += m.(int)
+			if m.(int) == 4 {
+				maxes++
+			}
+		}
+		if sum != res.Value || len(res.MetaTuple) != 2+maxes {
+			t.Fatalf("2d4!: inconsistent result %d %v", res.Value, res.MetaTuple)
+		}
+	}
+}
+
+func TestCompoundingAndPenetratingDice(t *testing.T) {
+	r := New("3d6!!>=4", nil)
+	r.rng = rand.New(rand.NewSource(5))
+	r.Roll()
+	res := r.Result()
+	if res.Error != "" {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	if len(res.MetaTuple) != 3 || res.Value != 39 {
+		t.Fatalf("compounding should keep 3 dice: %d %v", res.Value, res.MetaTuple)
+	}
+	if res.Detail != "39 [1,5!+2,5!+4!+5!+5!+4!+5!+3] min=3 max=∞" {
+		t.Fatalf("unexpected detail %q", res.Detail)
+	}
+
+	rng := rand.New(rand.NewSource(11))
+	for i := 0; i < 200; i++ {
+		r := New("1d3!p", nil)
+		r.rng = rng
+		r.Roll()
+		meta := r.Result().MetaTuple
+		for j, m := range meta[1:] {
+			// follow-up dice are reduced by one, so only a 3 (or a penetrated 2) continues
+			if m.(int) < 0 || m.(int) > 2 {
+				t.Fatalf("penetrating die out of range: %v", meta)
+			}
+			prev := meta[j].(int)
+			if (j == 0 && prev != 3) || (j > 0 && prev != 2) {
+				t.Fatalf("unexpected penetration after %d: %v", prev, meta)
+			}
+		}
+	}
+}
+
+func TestExplodeErrors(t *testing.T) {
+	cases := map[string]ErrorType{
+		"1d6!>0":  ErrInputChildParaInvalid,
+		"1d6!<=6": ErrInputChildParaInvalid,
+		"3d6!!!":  ErrInputNodeOperationInvalid,
+		"3d6!>":   ErrNodeStackEmpty,
+	}
+	for expr, want := range cases {
+		r := New(expr, nil)
+		r.Roll()
+		if got := r.Result().Error; got != want {
+			t.Fatalf("%q: expected %v got %v", expr, want, got)
+		}
+	}
+
+	// the only face of 1d1! is also its max face
+	r := New("1d1!", nil)
+	r.Roll()
+	if r.Result().Error != ErrInputChildParaInvalid {
+		t.Fatalf("1d1! should be rejected, got %v", r.Result().Error)
+	}
+}
+
+func TestReroll(t *testing.T) {
+	r := New("8d6r1", nil)
+	r.rng = rand.New(rand.NewSource(5))
+	r.Roll()
+	res := r.Result()
+	if res.Error != "" {
+		t.Fatalf("unexpected error: %v", res.Error)
+	}
+	if res.Detail != "35 [1→5,2,5,4,5,5,4,5] min=8 max=48" {
+		t.Fatalf("unexpected detail %q", res.Detail)
+	}
+
+	// r keeps rerolling, so no die below the threshold can survive
+	rng := rand.New(rand.NewSource(13))
+	for i := 0; i < 200; i++ {
+		r := New("4d6r<3", nil)
+		r.rng = rng
+		r.Roll()
+		for _, m := range r.Result().MetaTuple {
+			if m.(int) < 3 {
+				t.Fatalf("4d6r<3 kept a low die: %v", r.Result().MetaTuple)
+			}
+		}
+	}
+
+	// ro rerolls at most once, so a second 1 is kept
+	seenOne := false
+	for i := 0; i < 500 && !seenOne; i++ {
+		r := New("1d2ro1", nil)
+		r.rng = rng
+		r.Roll()
+		res := r.Result()
+		if res.Value == 1 {
+			seenOne = true
+			if res.Detail != "1 [1→1] min=1 max=2" {
+				t.Fatalf("unexpected detail %q", res.Detail)
+			}
+		}
+	}
+	if !seenOne {
+		t.Fatalf("1d2ro1 never kept a rerolled 1")
+	}
+}
+
+func TestRerollErrors(t *testing.T) {
+	cases := map[string]ErrorType{
+		"1d6r<7":  ErrInputChildParaInvalid,
+		"1d6r":    ErrNodeStackEmpty,
+		"2d6r1r2": ErrInputNodeOperationInvalid,
+		"1d6ro<7": "",
+		"2d6r1!":  "",
+	}
+	for expr, want := range cases {
+		r := New(expr, nil)
+		r.Roll()
+		if got := r.Result().Error; got != want {
+			t.Fatalf("%q: expected %v got %v", expr, want, got)
+		}
+	}
+}
+
+func TestSuccessCounting(t *testing.T) {
+	cases := []struct {
+		expr string
+		want int
+	}{
+		// seed 5 rolls [7,7,10,1,8,7,7,10,7,5]
+		{"10d10cs>=8", 3},
+		{"10d10cs<=3", 1},
+		{"10d10cs=10", 2},
+		{"10d10cs10", 2},
+		{"10d10cs>=8f1", 2},
+		{"10d10cs>=7ds10", 10},
+		{"10d10cs>=7f1ds10", 9},
+		{"10d10cs>=7ds10f1", 9},
+	}
+	for _, c := range cases {
+		r := New(c.expr, nil)
+		r.rng = rand.New(rand.NewSource(5))
+		r.Roll()
+		res := r.Result()
+		if res.Error != "" {
+			t.Fatalf("%q: unexpected error: %v", c.expr, res.Error)
+		}
+		if res.Value != c.want {
+			t.Fatalf("%q: expected %d got %d", c.expr, c.want, res.Value)
+		}
+		// the raw pool stays in the metadata
+		if len(res.MetaTuple) != 10 || res.MetaTuple[2] != 10 {
+			t.Fatalf("%q: unexpected pool %v", c.expr, res.MetaTuple)
+		}
+	}
+
+	// comparison operators after a dice term compare the sum
+	for _, expr := range []string{"5d6>4", "2d6>=7", "2d6<=7", "2d6==7", "2d6!=7"} {
+		for seed := int64(0); seed < 20; seed++ {
+			r := New(expr, nil)
+			r.rng = rand.New(rand.NewSource(seed))
+			r.Roll()
+			paren := New("("+expr[:3]+")"+expr[3:], nil)
+			paren.rng = rand.New(rand.NewSource(seed))
+			paren.Roll()
+			got, want := r.Result(), paren.Result()
+			if got.Error != "" || got.Value != want.Value || got.Value > 1 {
+				t.Fatalf("%q seed %d: expected the comparison %d got %d", expr, seed, want.Value, got.Value)
+			}
+		}
+	}
+	if d, err := Distribution("3d6>=10?1:0"); err != nil || !almostEqual(d.Prob(1), 0.625, 1e-9) {
+		t.Fatalf("3d6>=10?1:0 should compare the sum: %v", err)
+	}
+
+	for expr, want := range map[string]ErrorType{"10d10cs>=8f": ErrNodeStackEmpty, "10d10cs>=8f1f2": ErrInputNodeOperationInvalid, "10d10cs": ErrNodeStackEmpty} {
+		r := New(expr, nil)
+		r.Roll()
+		if got := r.Result().Error; got != want {
+			t.Fatalf("%q: expected %v got %v", expr, want, got)
+		}
+	}
+}
+
+func TestComparisonAndLogical(t *testing.T) {
+	cases := map[string]int{
+		"3>=3":               1,
+		"3<=2":               0,
+		"2==2":               1,
+		"2!=2":               0,
+		"!0":                 1,
+		"!5":                 0,
+		"!0+1":               2,
+		"!!3":                1,
+		"1&&0":               0,
+		"1&&2":               1,
+		"0||0":               0,
+		"1 >= 2 || 2 >= 1":   1,
+		"1<2&3":              1,
+		"2&3==2":             1,
+		"1||0&&0":            1,
+		"{AC}>=15?2:3":       2,
+		"{AC}!=15?2:3":       3,
+		"1d20+5>={AC}||1":    1,
+		"(1?2:3)==2&&5|2==7": 1,
+	}
+	for expr, want := range cases {
+		r := New(expr, map[string]int{"AC": 15})
+		r.Roll()
+		res := r.Result()
+		if res.Error != "" {
+			t.Fatalf("%q: unexpected error: %v", expr, res.Error)
+		}
+		if res.Value != want {
+			t.Fatalf("%q: expected %d got %d", expr, want, res.Value)
+		}
+	}
+}
+
+func TestLogicalShortCircuit(t *testing.T) {
+	for _, expr := range []string{"1||1/0", "0&&1/0", "0&&($t=5)"} {
+		r := New(expr, nil)
+		r.Roll()
+		res := r.Result()
+		if res.Error != "" {
+			t.Fatalf("%q: right side should not be evaluated: %v", expr, res.Error)
+		}
+		if len(r.temp) != 0 {
+			t.Fatalf("%q: right side assignment leaked: %v", expr, r.temp)
+		}
+	}
+	r := New("1&&1/0", nil)
+	r.Roll()
+	if r.Result().Error != ErrNodeRightValInvalid {
+		t.Fatalf("1&&1/0 should fail, got %v", r.Result().Error)
+	}
+}
+
+func TestUnaryMinusPlus(t *testing.T) {
+	cases := map[string]int{
+		"-3":         -3,
+		"+5":         5,
+		"-3+4":       1,
+		"4+-3":       1,
+		"4-+3":       1,
+		"3--2":       5,
+		"1+-+-1":     2,
+		"2*-3":       -6,
+		"-6/2":       -3,
+		"6/-2":       -3,
+		"-2^2":       -4,
+		"(-2)^2":     4,
+		"2*-3^2":     -18,
+		"-(1+2)":     -3,
+		"-2?1:2":     1,
+		"!-1":        0,
+		"-[1,5]kh1":  -5,
+		"[-1,-5]kl1": -5,
+		"$t=-4":      -4,
+		"1>-1":       1,
+	}
+	for expr, want := range cases {
+		r := New(expr, nil)
+		r.Roll()
+		res := r.Result()
+		if res.Error != "" {
+			t.Fatalf("%q: unexpected error: %v", expr, res.Error)
+		}
+		if res.Value != want {
+			t.Fatalf("%q: expected %d got %d", expr, want, res.Value)
+		}
+	}
+
+	// unary minus applies to the whole dice roll
+	for _, expr := range []string{"-2d6", "-d6", "1d6*-1", "-3+1d6"} {
+		r := New(expr, nil)
+		r.rng = rand.New(rand.NewSource(5))
+		r.Roll()
+		res := r.Result()
+		if res.Error != "" {
+			t.Fatalf("%q: unexpected error: %v", expr, res.Error)
+		}
+		if res.Value < res.Min || res.Value > res.Max || res.Max > 3 {
+			t.Fatalf("%q: value %d outside %d..%d", expr, res.Value, res.Min, res.Max)
+		}
+	}
+
+	errCases := map[string]ErrorType{
+		"(-2)d6": ErrNodeExtremeValInvalid,
+		"2d(-6)": ErrNodeExtremeValInvalid,
+		"2^-1":   ErrNodeRightValInvalid,
+		"-":      ErrNodeStackEmpty,
+		"1*-":    ErrNodeStackEmpty,
+	}
+	for expr, want := range errCases {
+		r := New(expr, nil)
+		r.Roll()
+		if got := r.Result().Error; got != want {
+			t.Fatalf("%q: expected %v got %v", expr, want, got)
+		}
+	}
+
+	// negative compare values in modifiers
+	r := New("4d6r-1", nil)
+	r.Roll()
+	if res := r.Result(); res.Error != "" || len(res.MetaTuple) != 4 {
+		t.Fatalf("4d6r-1 should never reroll: %v %v", res.Error, res.MetaTuple)
+	}
+}
+
+func TestDiceAnnotations(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	for i := 0; i < 200; i++ {
+		r := New("4d6kh3", nil)
+		r.rng = rng
+		r.Roll()
+		res := r.Result()
+		if len(res.Dice) != 4 {
+			t.Fatalf("4d6kh3 should report 4 dice, got %+v", res.Dice)
+		}
+		kept, dropped, lowest, drop := 0, 0, 6, 0
+		for _, d := range res.Dice {
+			if d.Op != "d" || d.Faces != 6 || d.Critical != (d.Value == 6) || d.Fumble != (d.Value == 1) {
+				t.Fatalf("4d6kh3 bad die %+v", d)
+			}
+			if d.Dropped {
+				dropped++
+				drop = d.Value
+				continue
+			}
+			kept += d.Value
+			if d.Value < lowest {
+				lowest = d.Value
+			}
+		}
+		if dropped != 1 || kept != res.Value || drop > lowest {
+			t.Fatalf("4d6kh3 should drop the lowest die: %+v value %d", res.Dice, res.Value)
+		}
+	}
+
+	r := New("1d20+5", nil)
+	r.Roll()
+	if res := r.Result(); len(res.Dice) != 1 || res.Dice[0].Value != res.Value-5 || res.Dice[0].Faces != 20 {
+		t.Fatalf("1d20+5 dice mismatch: %+v", res.Dice)
+	}
+
+	r = New("3d6!>=5", nil)
+	r.rng = rand.New(rand.NewSource(5))
+	r.Roll()
+	res := r.Result()
+	exploded := 0
+	for _, d := range res.Dice {
+		if d.Exploded {
+			exploded++
+			if d.Value < 5 {
+				t.Fatalf("die below the explode point marked exploded: %+v", d)
+			}
+		}
+	}
+	if len(res.Dice) != 5 || exploded != 2 {
+		t.Fatalf("3d6!>=5 expected 5 dice with 2 explosions: %+v", res.Dice)
+	}
+
+	r = New("8d6r1", nil)
+	r.rng = rand.New(rand.NewSource(5))
+	r.Roll()
+	if d := r.Result().Dice[0]; len(d.Rerolls) != 1 || d.Rerolls[0] != 1 || d.Value != 5 {
+		t.Fatalf("8d6r1 first die should be rerolled from 1 to 5: %+v", d)
+	}
+
+	// sp keeps one die, tp drops one die
+	r = New("4d6sp2", nil)
+	r.Roll()
+	for i, d := range r.Result().Dice {
+		if d.Dropped != (i != 1) {
+			t.Fatalf("4d6sp2 dropped flags mismatch: %+v", r.Result().Dice)
+		}
+	}
+	r = New("4d6tp-1", nil)
+	r.Roll()
+	for i, d := range r.Result().Dice {
+		if d.Dropped != (i == 3) {
+			t.Fatalf("4d6tp-1 dropped flags mismatch: %+v", r.Result().Dice)
+		}
+	}
+
+	// the unused tens dice of b are dropped
+	r = New("1b3", nil)
+	r.Roll()
+	res = r.Result()
+	kept := 0
+	for _, d := range res.Dice {
+		if !d.Dropped {
+			kept++
+		}
+	}
+	if len(res.Dice) != 5 || kept != 2 {
+		t.Fatalf("1b3 should keep two of five d10: %+v", res.Dice)
+	}
+
+	r = New("4df", nil)
+	r.Roll()
+	for _, d := range r.Result().Dice {
+		if d.Op != "f" || d.Critical != (d.Value == 1) || d.Fumble != (d.Value == -1) {
+			t.Fatalf("4df bad die %+v", d)
+		}
+	}
+}
+
+func TestKeepPreservesOrder(t *testing.T) {
+	cases := map[string][]int{
+		"[4,2,6]kh2":  {4, 6},
+		"[4,2,6]kl2":  {4, 2},
+		"[4,2,6]dh1":  {4, 2},
+		"[4,2,6]dl1":  {4, 6},
+		"[5,1,5,3]q3": {5, 1, 3},
+	}
+	for expr, want := range cases {
+		res := MustCompile(expr).Roll()
+		if len(res.MetaTuple) != len(want) {
+			t.Fatalf("%q: expected %v got %v", expr, want, res.MetaTuple)
+		}
+		for i, v := range want {
+			if res.MetaTuple[i].(int) != v {
+				t.Fatalf("%q: expected %v got %v", expr, want, res.MetaTuple)
+			}
+		}
+	}
+
+	// positional selection sees the kept elements in roll order
+	if res := MustCompile("[4,2,6]kh2sp1").Roll(); res.Value != 4 {
+		t.Fatalf("[4,2,6]kh2sp1 expected 4 got %d", res.Value)
+	}
+
+	// the old sorted order is still available
+	res := MustCompile("[4,2,6]kh2").Roll(WithSortedKeep())
+	if res.MetaTuple[0].(int) != 6 || res.MetaTuple[1].(int) != 4 {
+		t.Fatalf("sorted kh expected [6 4] got %v", res.MetaTuple)
+	}
+	r := New("[4,2,6]kl2", nil)
+	r.SortedKeep = true
+	r.Roll()
+	if m := r.Result().MetaTuple; m[0].(int) != 2 || m[1].(int) != 4 {
+		t.Fatalf("sorted kl expected [2 4] got %v", m)
+	}
+
+	// kept dice line up with the roll order and the dropped die stays in place
+	r = New("4d6kh3", nil)
+	r.rng = rand.New(rand.NewSource(42))
+	r.Roll()
+	out := r.Result()
+	j := 0
+	for _, d := range out.Dice {
+		if d.Dropped {
+			continue
+		}
+		if out.MetaTuple[j].(int) != d.Value {
+			t.Fatalf("4d6kh3 meta %v does not follow dice %+v", out.MetaTuple, out.Dice)
+		}
+		j++
+	}
+}
+
+func TestGroupKeepsMetadata(t *testing.T) {
+	for _, pair := range [][2]string{
+		{"(4d6)kh3", "4d6kh3"},
+		{"(2d6)sp1", "2d6sp1"},
+		{"((1d6)lp3)", "1d6lp3"},
+		{"(2d6)d6", "2d6d6"},
+		{"([4,2,6])kh2", "[4,2,6]kh2"},
+	} {
+		a := MustCompile(pair[0]).Roll(WithRNG(rand.New(rand.NewSource(9))))
+		b := MustCompile(pair[1]).Roll(WithRNG(rand.New(rand.NewSource(9))))
+		if a.Error != "" || a.Value != b.Value || len(a.MetaTuple) != len(b.MetaTuple) || len(a.Dice) != len(b.Dice) {
+			t.Fatalf("%q should match %q: %q vs %q", pair[0], pair[1], a.Detail, b.Detail)
+		}
+		if a.Min != b.Min || a.Max != b.Max {
+			t.Fatalf("%q bounds %d..%d differ from %q %d..%d", pair[0], a.Min, a.Max, pair[1], b.Min, b.Max)
+		}
+	}
+
+	res := MustCompile(`("{i}d6")lp2`).Roll()
+	if len(res.MetaTuple) != 2 || res.MetaTuple[1].(string) != "2d6" {
+		t.Fatalf("string templates should survive parentheses: %v", res.MetaTuple)
+	}
+
+	// a parenthesized temp variable can still be assigned
+	r := New("($1)=4", nil)
+	r.Roll()
+	if res := r.Result(); res.Error != "" || res.Value != 4 || r.temp[1] != 4 {
+		t.Fatalf("($1)=4 failed: %v %d", res.Error, res.Value)
+	}
+
+	// grouping keeps the ternary short-circuit
+	r = New("(1?2:(1/0))", nil)
+	r.Roll()
+	if res := r.Result(); res.Error != "" || res.Value != 2 {
+		t.Fatalf("ternary in parentheses should short-circuit: %v %d", res.Error, res.Value)
+	}
+}
+
+func TestRollResetsTemp(t *testing.T) {
+	r := New("$t1=3", nil)
+	r.Roll()
+	r.Expr = "$t2=4"
+	r.Roll()
+	if _, ok := r.temp[1]; ok || r.temp[2] != 4 {
+		t.Fatalf("temp table should start empty on every roll: %v", r.temp)
+	}
+
+	// the assignment is not visible to the next roll through the value table either
+	vt := map[string]int{"STR": 3}
+	r = New("$t1=5", vt, WithStrictVariables())
+	r.Roll()
+	r.Expr = "$t1"
+	r.Roll()
+	if res := r.Result(); res.Error != ErrInputChildParaInvalid || len(vt) != 1 {
+		t.Fatalf("$t1 should be unassigned on the next roll: %d %v %v", res.Value, res.Err, vt)
+	}
+	p := MustCompile("($t1=1d6)+{STR}")
+	if res := p.Roll(WithValueTable(vt)); res.Error != "" || len(vt) != 1 {
+		t.Fatalf("Program should not write into the caller's table: %v %v", res.Err, vt)
+	}
+}
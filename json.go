@@ -0,0 +1,146 @@
+package gonedice
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// JSONSchema 选择 Result 序列化为 JSON 时使用的字段布局
+type JSONSchema int
+
+const (
+	// SchemaOneDice 与 OneDice 参考实现的输出字段一致，是 MarshalJSON 的默认布局：
+	//
+	//	resInt        结果的值
+	//	resIntMin     可能的最小值
+	//	resIntMax     可能的最大值
+	//	resDetail     Result.Detail
+	//	resError      错误类型，没有错误时为 null
+	//	resMetaTuple  元数据列表，没有元数据时为 []
+	//
+	// 此外还包含扩展字段 expr、resIntMinOpen、resIntMaxOpen、errDetail、dice、trace 与 record，
+	// 取默认值时省略
+	SchemaOneDice JSONSchema = iota
+	// SchemaNative 使用 Result 的 Go 字段名，如 Value、Min、MetaTuple、Err
+	SchemaNative
+)
+
+// oneDiceResult 是 SchemaOneDice 的 JSON 布局
+type oneDiceResult struct {
+	Expr          string        `json:"expr,omitempty"`
+	ResInt        int           `json:"resInt"`
+	ResIntMin     int           `json:"resIntMin"`
+	ResIntMax     int           `json:"resIntMax"`
+	ResIntMinOpen bool          `json:"resIntMinOpen,omitempty"`
+	ResIntMaxOpen bool          `json:"resIntMaxOpen,omitempty"`
+	ResDetail     string        `json:"resDetail"`
+	ResError      *ErrorType    `json:"resError"`
+	ResMetaTuple  []interface{} `json:"resMetaTuple"`
+	ErrDetail     *Error        `json:"errDetail,omitempty"`
+	Dice          []Die         `json:"dice,omitempty"`
+	Trace         *Trace        `json:"trace,omitempty"`
+	Record        *Record       `json:"record,omitempty"`
+}
+
+// nativeResult 是 SchemaNative 的 JSON 布局，去掉了 Result 的方法以使用默认编码
+type nativeResult Result
+
+// MarshalJSON 实现 json.Marshaler，使用 SchemaOneDice 布局
+func (r Result) MarshalJSON() ([]byte, error) {
+	return r.MarshalJSONSchema(SchemaOneDice)
+}
+
+// MarshalJSONSchema 使用指定的布局序列化结果
+func (r Result) MarshalJSONSchema(schema JSONSchema) ([]byte, error) {
+	if schema == SchemaNative {
+		return json.Marshal(nativeResult(r))
+	}
+	out := oneDiceResult{
+		Expr:          r.Expr,
+		ResInt:        r.Value,
+		ResIntMin:     r.Min,
+		ResIntMax:     r.Max,
+		ResIntMinOpen: r.MinOpen,
+		ResIntMaxOpen: r.MaxOpen,
+		ResDetail:     r.Detail,
+		ResMetaTuple:  r.MetaTuple,
+		ErrDetail:     r.Err,
+		Dice:          r.Dice,
+		Trace:         r.Trace,
+		Record:        r.Record,
+	}
+	if r.Error != "" {
+		out.ResError = &r.Error
+	}
+	if out.ResMetaTuple == nil {
+		out.ResMetaTuple = []interface{}{}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON 实现 json.Unmarshaler，根据是否包含 resInt 字段自动识别两种布局
+// 元数据中的整数还原为 int，与求值得到的 MetaTuple 一致
+func (r *Result) UnmarshalJSON(data []byte) error {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	if _, ok := keys["resInt"]; !ok {
+		var n nativeResult
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		*r = Result(n)
+	} else {
+		var in oneDiceResult
+		if err := json.Unmarshal(data, &in); err != nil {
+			return err
+		}
+		*r = Result{
+			Expr:      in.Expr,
+			Value:     in.ResInt,
+			Min:       in.ResIntMin,
+			Max:       in.ResIntMax,
+			MinOpen:   in.ResIntMinOpen,
+			MaxOpen:   in.ResIntMaxOpen,
+			Detail:    in.ResDetail,
+			MetaTuple: in.ResMetaTuple,
+			Err:       in.ErrDetail,
+			Dice:      in.Dice,
+			Trace:     in.Trace,
+			Record:    in.Record,
+		}
+		if in.ResError != nil {
+			r.Error = *in.ResError
+		}
+		if len(r.MetaTuple) == 0 {
+			r.MetaTuple = nil
+		}
+	}
+	r.MetaTuple = intMeta(r.MetaTuple)
+	r.Trace.walk(func(t *Trace) {
+		t.MetaTuple = intMeta(t.MetaTuple)
+	})
+	return nil
+}
+
+// intMeta 将 JSON 解码得到的整数值 float64 还原为 int
+func intMeta(meta []interface{}) []interface{} {
+	for i, m := range meta {
+		if f, ok := m.(float64); ok && f == math.Trunc(f) {
+			meta[i] = int(f)
+		}
+	}
+	return meta
+}
+
+// walk 按先序遍历步骤树
+func (t *Trace) walk(fn func(*Trace)) {
+	if t == nil {
+		return
+	}
+	fn(t)
+	for _, in := range t.Inputs {
+		in.walk(fn)
+	}
+}
@@ -0,0 +1,73 @@
+package gonedice
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResultJSONOneDice(t *testing.T) {
+	res := MustCompile("4d6kh3", WithRNG(&fixedSource{faces: []int{6, 4, 3, 1}}), WithTrace()).Roll()
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]interface{}{
+		"resInt": 13.0, "resIntMin": 3.0, "resIntMax": 18.0, "resDetail": "13 [6,4,3] min=3 max=18", "resError": nil, "expr": "4d6kh3",
+	} {
+		if raw[key] != want {
+			t.Fatalf("%s: expected %v got %v in %s", key, want, raw[key], data)
+		}
+	}
+	if !reflect.DeepEqual(raw["resMetaTuple"], []interface{}{6.0, 4.0, 3.0}) {
+		t.Fatalf("unexpected resMetaTuple in %s", data)
+	}
+	if !strings.Contains(string(data), `{"value":1,"faces":6,"op":"d","dropped":true,"fumble":true}`) {
+		t.Fatalf("dice annotations missing in %s", data)
+	}
+
+	var back Result
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Value != 13 || back.Expr != "4d6kh3" || !reflect.DeepEqual(back.MetaTuple, res.MetaTuple) || !reflect.DeepEqual(back.Dice, res.Dice) {
+		t.Fatalf("round trip mismatch: %+v", back)
+	}
+	if back.Trace.String() != res.Trace.String() || !reflect.DeepEqual(back.Trace.Inputs[0].MetaTuple, []interface{}{6, 4, 3, 1}) {
+		t.Fatalf("trace round trip mismatch: %v", back.Trace)
+	}
+
+	// errors carry the error type and the located error
+	res = MustCompile("1/0").Roll()
+	data, _ = json.Marshal(res)
+	if !strings.Contains(string(data), `"resError":"`+string(ErrNodeRightValInvalid)+`"`) || !strings.Contains(string(data), `"resMetaTuple":[]`) {
+		t.Fatalf("unexpected error json %s", data)
+	}
+	back = Result{}
+	if err := json.Unmarshal(data, &back); err != nil || back.Error != ErrNodeRightValInvalid || back.Err.Token != res.Err.Token {
+		t.Fatalf("error round trip mismatch: %+v %v", back, err)
+	}
+}
+
+func TestResultJSONNative(t *testing.T) {
+	res := MustCompile(`"a{i}"lp2`, WithRecord()).Roll()
+	data, err := res.MarshalJSONSchema(SchemaNative)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"MetaTuple":["a1","a2"]`) || strings.Contains(string(data), "resInt") {
+		t.Fatalf("unexpected native json %s", data)
+	}
+	var back Result
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Detail != res.Detail || !reflect.DeepEqual(back.MetaTuple, res.MetaTuple) || back.Record.Value != res.Record.Value {
+		t.Fatalf("native round trip mismatch: %+v", back)
+	}
+}
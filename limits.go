@@ -0,0 +1,65 @@
+package gonedice
+
+// Limits 限制一次求值可以使用的资源，用于处理不可信的输入
+// 字段为 0 时使用 DefaultLimits 中的值，为负数时不做限制
+// 超出任一限制时求值失败，错误类型为 ErrLimitExceeded
+type Limits struct {
+	// MaxExprLen 表达式的最大字节数，lp 模板展开后求值的子表达式同样受限
+	MaxExprLen int
+	// MaxDepth 表达式的最大嵌套深度（括号、前缀运算与右结合运算都会加深嵌套）
+	MaxDepth int
+	// MaxOps 一次求值最多执行的运算次数，乘方的每次乘法都计为一次运算
+	MaxOps int
+	// MaxDice 一次求值最多掷出的骰子数，包括重投、爆炸与 a/c 追加的骰子
+	MaxDice int
+	// MaxTupleLen 骰池与多元组元数据的最大长度
+	MaxTupleLen int
+	// MaxStringOutput lp 展开字符串模板后输出的最大总字节数
+	MaxStringOutput int
+	// MaxDistWork Distribution 最多执行的计算步数，卷积的每次乘加计为一步，保留类运算的每次状态转移计为 16 步
+	MaxDistWork int
+}
+
+// DefaultLimits 返回默认的资源限制
+// 默认值足以容纳 d 允许的最大骰池（10000 颗骰子，每颗最多爆炸 100 次）
+func DefaultLimits() Limits {
+	return Limits{
+		MaxExprLen:      1 << 16,
+		MaxDepth:        256,
+		MaxOps:          1 << 20,
+		MaxDice:         2_000_000,
+		MaxTupleLen:     2_000_000,
+		MaxStringOutput: 1 << 20,
+		MaxDistWork:     100_000_000,
+	}
+}
+
+// resolve 将为 0 的字段替换为默认值
+func (l Limits) resolve() Limits {
+	d := DefaultLimits()
+	for _, f := range []struct{ v, def *int }{
+		{&l.MaxExprLen, &d.MaxExprLen},
+		{&l.MaxDepth, &d.MaxDepth},
+		{&l.MaxOps, &d.MaxOps},
+		{&l.MaxDice, &d.MaxDice},
+		{&l.MaxTupleLen, &d.MaxTupleLen},
+		{&l.MaxStringOutput, &d.MaxStringOutput},
+		{&l.MaxDistWork, &d.MaxDistWork},
+	} {
+		if *f.v == 0 {
+			*f.v = *f.def
+		}
+	}
+	return l
+}
+
+// exceeds 判断 v 是否超出限制 max，max 为负数表示不限制
+func exceeds(v, max int) bool {
+	return max >= 0 && v > max
+}
+
+// budget 记录一次求值已经使用的资源，lp 模板的子表达式与调用者共享
+type budget struct {
+	ops  int
+	dice int
+}
@@ -0,0 +1,87 @@
+package gonedice
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLimitsExceeded(t *testing.T) {
+	cases := []struct {
+		expr  string
+		lim   Limits
+		token string
+	}{
+		{"1+1+1", Limits{MaxExprLen: 3}, "+1"},
+		{"((((1))))", Limits{MaxDepth: 3}, "("},
+		{"----1", Limits{MaxDepth: 3}, "-"},
+		{"2^100", Limits{MaxOps: 50}, "2^100"},
+		{"1+1+1+1", Limits{MaxOps: 5}, "1"},
+		{"100d6", Limits{MaxDice: 99}, "100d6"},
+		{"10d6!", Limits{MaxDice: 10}, "10d6!"},
+		{"1lp1000000000", Limits{}, "1lp1000000000"},
+		{"3d6lp5", Limits{MaxTupleLen: 14}, "3d6lp5"},
+		{"[1,2,3,4]", Limits{MaxTupleLen: 3}, "[1,2,3,4]"},
+		{`"abcdef"lp3`, Limits{MaxStringOutput: 12}, `"abcdef"lp3`},
+		{`"1d6"lp2kh1`, Limits{MaxDice: 1}, "1d6"},
+		{"1a1", Limits{}, "1a1"},
+		{"5a1", Limits{MaxDice: 1000}, "5a1"},
+		{"5c1", Limits{MaxDice: -1, MaxTupleLen: 100}, "5c1"},
+	}
+	for _, c := range cases {
+		p, err := Compile(c.expr, WithLimits(c.lim))
+		var res Result
+		if err != nil {
+			res = Result{Error: err.(*Error).Code, Err: err.(*Error)}
+		} else {
+			res = p.Roll(WithSeed(1))
+		}
+		if res.Error != ErrLimitExceeded {
+			t.Fatalf("%q: expected limit error got %q (%v)", c.expr, res.Detail, res.Error)
+		}
+		if res.Err.Token != c.token {
+			t.Fatalf("%q: error should point at %q got %q", c.expr, c.token, res.Err.Token)
+		}
+	}
+}
+
+func TestLimitsAllowDefaults(t *testing.T) {
+	// the largest pools the operators accept fit in the default limits
+	for _, expr := range []string{"10000d10000", "10000d2!", "3a2", `"{i}"lp1000`, "2^62", "10000d6lp10"} {
+		r := New(expr, nil, WithSeed(1))
+		r.Roll()
+		if res := r.Result(); res.Error != "" {
+			t.Fatalf("%q: unexpected error %v", expr, res.Err)
+		}
+	}
+
+	// negative values disable a limit, zero keeps the default
+	deep := strings.Repeat("(", 300) + "1" + strings.Repeat(")", 300)
+	if _, err := Compile(deep); err == nil {
+		t.Fatalf("300 nested parentheses should exceed the default depth")
+	}
+	if _, err := Compile(deep, WithLimits(Limits{MaxDepth: -1})); err != nil {
+		t.Fatalf("MaxDepth -1 should disable the limit: %v", err)
+	}
+	r := New("2^40", nil)
+	r.Limits = Limits{MaxOps: 10}
+	r.Roll()
+	if r.Result().Error != ErrLimitExceeded {
+		t.Fatalf("RD.Limits should be enforced, got %v", r.Result().Error)
+	}
+
+	// parse-time limits set after the first roll apply to the cached program
+	r = New("1+1+1+1+1", nil)
+	r.Roll()
+	for _, lim := range []Limits{{MaxExprLen: 3}, {MaxDepth: 1}} {
+		r.Limits = lim
+		r.Roll()
+		if r.Result().Error != ErrLimitExceeded {
+			t.Fatalf("%+v should apply after the first roll, got %v", lim, r.Result().Value)
+		}
+	}
+	r.Limits = Limits{}
+	r.Roll()
+	if res := r.Result(); res.Error != "" || res.Value != 5 {
+		t.Fatalf("restoring the limits should recompile: %v", res.Err)
+	}
+}
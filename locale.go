@@ -0,0 +1,242 @@
+package gonedice
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Locale 是一套本地化的消息目录，用于错误信息、Detail 中的标签与 REPL 的提示
+// Messages 的键分为以下几类，缺少的键使用默认文本：
+//
+//	错误类型的代码，如 "NODE_RIGHT_VAL_INVALID"：错误类型的说明
+//	错误的英文格式串，如 "division by zero"、"undefined variable %s"：Error.Msg 的译文，参数顺序不变
+//	"error.sep"、"error.op"、"error.at"、"error.near"：错误信息中的分隔符、运算符、位置与附近文本
+//	"detail.min"、"detail.max"、"detail.temp"、"detail.vt"：Detail 中的标签
+//	"repl.banner"、"repl.value"、"repl.meta"、"repl.detail"、"repl.error"、"repl.history"：REPL 的提示
+type Locale struct {
+	// Tag 语言标签，如 "zh-CN"、"en-US"
+	Tag string
+	// Messages 消息键到文本的映射
+	Messages map[string]string
+}
+
+// defaultMessages 是未指定 Locale 时使用的文本，与引入消息目录之前的输出一致
+var defaultMessages = map[string]string{
+	"error.sep":    ": ",
+	"error.op":     " (op %q)",
+	"error.at":     " at %d",
+	"error.near":   " near %q",
+	"detail.min":   "min",
+	"detail.max":   "max",
+	"detail.temp":  "temp",
+	"detail.vt":    "vt",
+	"repl.banner":  "gonedice REPL - 输入 OneDice 表达式或 'quit' 退出",
+	"repl.value":   "Value",
+	"repl.meta":    "Meta",
+	"repl.detail":  "Detail",
+	"repl.error":   "Error",
+	"repl.history": "本次会话的输入历史:",
+}
+
+// LocaleZhCN 是内置的简体中文消息目录
+var LocaleZhCN = &Locale{Tag: "zh-CN", Messages: map[string]string{
+	"UNKNOWN_GENERATE_FATAL":       "未知的生成错误",
+	"INPUT_RAW_INVALID":            "输入表达式无效",
+	"INPUT_CHILD_PARA_INVALID":     "子参数无效",
+	"INPUT_NODE_OPERATION_INVALID": "输入运算无效",
+	"NODE_OPERATION_INVALID":       "节点运算无效",
+	"NODE_STACK_EMPTY":             "节点栈为空",
+	"NODE_LEFT_VAL_INVALID":        "节点左侧值无效",
+	"NODE_RIGHT_VAL_INVALID":       "节点右侧值无效",
+	"NODE_SUB_VAL_INVALID":         "节点子值无效",
+	"NODE_EXTREME_VAL_INVALID":     "节点值超出范围",
+	"UNKNOWN_COMPLETE_FATAL":       "未知的完成错误",
+	"LIMIT_EXCEEDED":               "超出资源限制",
+	"EVAL_CANCELLED":               "求值被取消",
+	"REPLAY_MISMATCH":              "重放结果与记录不一致",
+	"SESSION_REVEALED":             "会话种子已公开",
+	"FAIR_MISMATCH":                "公平性校验失败",
+	"CLIENT_SEED_INVALID":          "客户端种子无效",
+
+	"unterminated string literal":                    "字符串没有结束的引号",
+	"number out of range":                            "数字超出范围",
+	"unterminated variable reference":                "变量引用缺少 '}'",
+	"empty variable name":                            "变量名为空",
+	"unexpected char %q":                             "无法识别的字符 %q",
+	"unmatched ')'":                                  "多余的 ')'",
+	"unexpected token":                               "多余的记号",
+	"missing operand":                                "缺少操作数",
+	"missing ':' in ternary":                         "三元运算缺少 ':'",
+	"duplicate reroll modifier":                      "重复的重投修饰",
+	"duplicate explosion modifier":                   "重复的爆炸修饰",
+	"duplicate success modifier":                     "重复的成功计数修饰",
+	"unknown identifier":                             "未知的标识符",
+	"unclosed '('":                                   "括号没有闭合",
+	"unexpected '%c'":                                "多余的 '%c'",
+	"unterminated bracketed tuple":                   "多元组没有闭合",
+	"unexpected token in tuple":                      "多元组中有多余的记号",
+	"expression too long":                            "表达式过长",
+	"expression nested too deeply":                   "表达式嵌套过深",
+	"temp variable $%d is not assigned":              "临时变量 $%d 未赋值",
+	"undefined variable %s":                          "未定义的变量 %s",
+	"unknown node":                                   "未知的节点",
+	"unknown operator":                               "未知的运算符",
+	"dice count out of range":                        "骰子个数超出范围",
+	"dice faces out of range":                        "骰子面数超出范围",
+	"fudge faces out of range":                       "fudge 骰面数超出范围",
+	"bonus dice count out of range":                  "奖惩骰个数超出范围",
+	"threshold out of range":                         "阈值超出范围",
+	"operand out of range":                           "操作数超出范围",
+	"operand may be out of range":                    "操作数可能超出范围",
+	"reroll condition matches every face":            "重投条件匹配所有面",
+	"explosion condition matches every face":         "爆炸条件匹配所有面",
+	"division by zero":                               "除以零",
+	"zero to the power of zero":                      "零的零次方",
+	"negative exponent":                              "指数为负数",
+	"assignment target is not a temp variable":       "赋值目标不是临时变量",
+	"count must be positive":                         "个数必须为正数",
+	"bound must be positive":                         "界限必须为正数",
+	"operand is not a tuple":                         "操作数不是多元组",
+	"position out of range":                          "位置超出范围",
+	"too many operations":                            "运算次数过多",
+	"too many dice rolled":                           "掷骰数过多",
+	"tuple too long":                                 "多元组过长",
+	"string output too long":                         "字符串输出过长",
+	"distribution not supported for this expression": "无法计算该表达式的分布",
+	"distribution too large":                         "分布过大",
+	"distribution too expensive":                     "分布计算量过大",
+	"context canceled":                               "上下文已取消",
+	"context deadline exceeded":                      "求值超时",
+	"draw %d does not match the expression":          "第 %d 个随机数与表达式不符",
+	"%d of %d draws were not used":                   "有 %d 个随机数未被使用（共 %d 个）",
+	"replayed value %d differs from recorded %d":     "重放结果 %d 与记录的 %d 不同",
+	"server seed has been revealed":                  "服务端种子已公开",
+	"commitment has not been published":              "尚未公开承诺",
+	"client seed is empty":                           "客户端种子为空",
+	"client seed cannot change after the first roll": "开始掷骰后不能修改客户端种子",
+	"client seed has not been set":                   "尚未设置客户端种子",
+	"server seed is not hex: %v":                     "服务端种子不是十六进制: %v",
+	"server seed does not match the commitment":      "服务端种子与承诺不符",
+	"nonce %d replayed to %d, recorded %d":           "第 %d 次掷骰重新求值为 %d，记录为 %d",
+
+	"error.sep":    "：",
+	"error.op":     "（运算符 %q）",
+	"error.at":     "，位置 %d",
+	"error.near":   "，附近 %q",
+	"detail.min":   "最小",
+	"detail.max":   "最大",
+	"detail.temp":  "临时变量",
+	"detail.vt":    "变量表",
+	"repl.banner":  "gonedice REPL - 输入 OneDice 表达式或 'quit' 退出",
+	"repl.value":   "结果",
+	"repl.meta":    "元数据",
+	"repl.detail":  "详情",
+	"repl.error":   "错误",
+	"repl.history": "本次会话的输入历史:",
+}}
+
+// LocaleEnUS 是内置的英文消息目录，错误信息的英文格式串即为译文
+var LocaleEnUS = &Locale{Tag: "en-US", Messages: map[string]string{
+	"UNKNOWN_GENERATE_FATAL":       "unknown generation error",
+	"INPUT_RAW_INVALID":            "invalid input expression",
+	"INPUT_CHILD_PARA_INVALID":     "invalid child parameter",
+	"INPUT_NODE_OPERATION_INVALID": "invalid operation in input",
+	"NODE_OPERATION_INVALID":       "invalid node operation",
+	"NODE_STACK_EMPTY":             "node stack empty",
+	"NODE_LEFT_VAL_INVALID":        "invalid left operand",
+	"NODE_RIGHT_VAL_INVALID":       "invalid right operand",
+	"NODE_SUB_VAL_INVALID":         "invalid tuple element",
+	"NODE_EXTREME_VAL_INVALID":     "value out of range",
+	"UNKNOWN_COMPLETE_FATAL":       "unknown completion error",
+	"LIMIT_EXCEEDED":               "resource limit exceeded",
+	"EVAL_CANCELLED":               "evaluation cancelled",
+	"REPLAY_MISMATCH":              "replay does not match the record",
+	"SESSION_REVEALED":             "session seed already revealed",
+	"FAIR_MISMATCH":                "fairness verification failed",
+	"CLIENT_SEED_INVALID":          "invalid client seed",
+
+	"repl.banner":  "gonedice REPL - enter a OneDice expression or 'quit' to exit",
+	"repl.history": "Input history for this session:",
+}}
+
+var (
+	localeMu sync.RWMutex
+	locales  = map[string]*Locale{}
+)
+
+func init() {
+	RegisterLocale(LocaleZhCN)
+	RegisterLocale(LocaleEnUS)
+}
+
+// RegisterLocale 注册消息目录，已有相同 Tag 的目录会被替换，可在运行时调用
+// 注册后不应再修改 loc.Messages
+func RegisterLocale(loc *Locale) {
+	localeMu.Lock()
+	defer localeMu.Unlock()
+	locales[normalizeTag(loc.Tag)] = loc
+}
+
+// LookupLocale 按语言标签查找已注册的消息目录，标签不区分大小写，可以使用 "_" 分隔或带有编码后缀（如 zh_CN.UTF-8）
+// 没有完全匹配的标签时，返回语言相同的目录（如 "zh" 或 "zh-TW" 匹配 "zh-CN"）
+func LookupLocale(tag string) (*Locale, bool) {
+	tag = normalizeTag(tag)
+	localeMu.RLock()
+	defer localeMu.RUnlock()
+	if loc, ok := locales[tag]; ok {
+		return loc, true
+	}
+	lang, _, _ := strings.Cut(tag, "-")
+	tags := make([]string, 0, len(locales))
+	for t := range locales {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	for _, t := range tags {
+		if l, _, _ := strings.Cut(t, "-"); l == lang {
+			return locales[t], true
+		}
+	}
+	return nil, false
+}
+
+// normalizeTag 将标签规范化为小写并以 "-" 分隔，去掉编码后缀
+func normalizeTag(tag string) string {
+	tag, _, _ = strings.Cut(tag, ".")
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+// text 返回键对应的文本，目录中没有时依次使用默认文本与 def；l 可以为 nil
+func (l *Locale) text(key, def string) string {
+	if l != nil {
+		if s, ok := l.Messages[key]; ok {
+			return s
+		}
+	}
+	if s, ok := defaultMessages[key]; ok {
+		return s
+	}
+	return def
+}
+
+// format 查找格式串 key 的译文并格式化，没有译文时使用 key 本身
+func (l *Locale) format(key string, args ...any) string {
+	s := l.text(key, key)
+	if len(args) == 0 {
+		return s
+	}
+	return fmt.Sprintf(s, args...)
+}
+
+// errorType 渲染错误类型：目录中有对应代码的说明时为 "代码 说明"，否则为 ErrorType 本身
+func (l *Locale) errorType(t ErrorType) string {
+	code, _, _ := strings.Cut(string(t), " ")
+	if l != nil {
+		if s, ok := l.Messages[code]; ok {
+			return code + " " + s
+		}
+	}
+	return string(t)
+}
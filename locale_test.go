@@ -0,0 +1,81 @@
+package gonedice
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLocaleErrors(t *testing.T) {
+	cases := map[string]string{
+		"":      `NODE_RIGHT_VAL_INVALID 节点右侧值无效: division by zero (op "/") at 2 near "0"`,
+		"en-US": `NODE_RIGHT_VAL_INVALID invalid right operand: division by zero (op "/") at 2 near "0"`,
+		"zh-CN": `NODE_RIGHT_VAL_INVALID 节点右侧值无效：除以零（运算符 "/"），位置 2，附近 "0"`,
+	}
+	for tag, want := range cases {
+		res := MustCompile("1/0").Roll(WithLocale(tag))
+		if res.Err == nil || res.Err.Error() != want {
+			t.Fatalf("%q: expected %q got %v", tag, want, res.Err)
+		}
+		if !errors.Is(res.Err, ErrNodeRightValInvalid) || res.Err.Msg != "division by zero" {
+			t.Fatalf("%q: localization must not change Code or Msg: %+v", tag, res.Err)
+		}
+	}
+
+	// arguments are substituted into the translated format
+	_, err := Compile("1#2", WithLocale("zh-CN"))
+	if err == nil || !strings.Contains(err.Error(), "无法识别的字符 '#'") {
+		t.Fatalf("parse error should be localized: %v", err)
+	}
+	r := New("{X}+1", nil, WithLocale("zh-CN"))
+	r.Roll()
+	if res := r.Result(); res.Err == nil || !strings.Contains(res.Err.Error(), "未定义的变量 X") {
+		t.Fatalf("RD error should be localized: %v", res.Err)
+	}
+	if _, err := Distribution("1d0", WithLocale("zh-CN")); err == nil || !strings.Contains(err.Error(), "位置") {
+		t.Fatalf("Distribution error should be localized: %v", err)
+	}
+
+	// the same error can be rendered in any catalog
+	if got := MustCompile("1/0").Roll().Err.Localized(LocaleEnUS); !strings.HasPrefix(got, "NODE_RIGHT_VAL_INVALID invalid right operand") {
+		t.Fatalf("Localized should use the given catalog: %q", got)
+	}
+}
+
+func TestLocaleDetail(t *testing.T) {
+	p := MustCompile("($t1=2d6)", WithSeed(1))
+	if got := p.Roll().Detail; !strings.HasSuffix(got, "min=2 max=12") {
+		t.Fatalf("default labels changed: %q", got)
+	}
+	if got := p.Roll(WithLocale("zh-CN")).Detail; !strings.HasSuffix(got, "最小=2 最大=12") {
+		t.Fatalf("zh-CN labels not applied: %q", got)
+	}
+	got := p.Roll(WithLocale("zh-CN"), WithRenderer(VerboseRenderer{})).Detail
+	if !strings.Contains(got, "临时变量:{t1=") || !strings.Contains(got, "变量表:{T1=") {
+		t.Fatalf("zh-CN verbose labels not applied: %q", got)
+	}
+}
+
+func TestRegisterLocale(t *testing.T) {
+	RegisterLocale(&Locale{Tag: "ja-JP", Messages: map[string]string{
+		"NODE_RIGHT_VAL_INVALID": "右辺の値が無効です",
+		"division by zero":       "ゼロ除算",
+		"detail.min":             "最小値",
+	}})
+	res := MustCompile("1/0").Roll(WithLocale("ja"))
+	if got := res.Err.Error(); got != `NODE_RIGHT_VAL_INVALID 右辺の値が無効です: ゼロ除算 (op "/") at 2 near "0"` {
+		t.Fatalf("registered locale not used, missing keys should fall back: %q", got)
+	}
+	if got := MustCompile("2d6").Roll(WithLocale("ja-JP")).Detail; !strings.HasSuffix(got, "最小値=2 max=12") {
+		t.Fatalf("registered detail labels not used: %q", got)
+	}
+
+	for tag, want := range map[string]*Locale{"zh_CN.UTF-8": LocaleZhCN, "EN": LocaleEnUS, "zh-TW": LocaleZhCN} {
+		if loc, ok := LookupLocale(tag); !ok || loc != want {
+			t.Fatalf("%q: expected %s got %v", tag, want.Tag, loc)
+		}
+	}
+	if _, ok := LookupLocale("C"); ok {
+		t.Fatalf("unknown tag should not match")
+	}
+}
@@ -0,0 +1,81 @@
+package gonedice
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// halfWidth 是全角字母数字与运算符以外需要转换的字符，如中文输入法输出的标点
+var halfWidth = map[rune]byte{
+	'　': ' ', // 全角空格
+	'、': ',',
+	'【': '[',
+	'】': ']',
+	'“': '"',
+	'”': '"',
+	'×': '*',
+	'÷': '/',
+}
+
+// toHalfWidth 返回字符对应的半角 ASCII 字符，没有对应字符时 ok 为 false
+// U+FF01 至 U+FF5E 的全角字符（数字、字母、运算符、中文逗号、冒号与括号等）与 ASCII 一一对应
+func toHalfWidth(r rune) (b byte, ok bool) {
+	if r >= 0xFF01 && r <= 0xFF5E {
+		return byte(r - 0xFEE0), true
+	}
+	b, ok = halfWidth[r]
+	return b, ok
+}
+
+// Normalize 将表达式中的全角字母数字、全角运算符、中文标点与 ×、÷ 转换为对应的 ASCII 字符
+// 字符串字面量与变量引用 {NAME} 的内容保持不变
+// 例如 "１ｄ２０＋５" 转换为 "1d20+5"，"（2d6）×2" 转换为 "(2d6)*2"
+func Normalize(expr string) string {
+	s, _ := normalize(expr)
+	return s
+}
+
+// normalize 与 Normalize 相同，同时返回位置映射 offsets：
+// 结果中第 i 个字节来自 expr 中从 offsets[i] 开始的字符，offsets[len(结果)] 为 len(expr)
+// 每个被转换的字符恰好变为一个字节，因此区间 [pos, end) 对应原始表达式的 [offsets[pos], offsets[end])
+func normalize(expr string) (string, []int) {
+	var sb strings.Builder
+	offsets := make([]int, 0, len(expr)+1)
+	// inside 为当前所在字符串或变量引用的结束字符，为 0 表示不在其中
+	var inside byte
+	escaped := false
+	for i := 0; i < len(expr); {
+		r, size := utf8.DecodeRuneInString(expr[i:])
+		b, ok := toHalfWidth(r)
+		c := b
+		if r < utf8.RuneSelf {
+			c = byte(r)
+		}
+		switch {
+		case escaped:
+			escaped, ok = false, false
+		case inside == '"' && r == '\\':
+			escaped = true
+		case inside != 0 && c == inside:
+			inside = 0
+		case inside != 0:
+			ok = false
+		case c == '"':
+			inside = '"'
+		case c == '{':
+			inside = '}'
+		}
+		if ok {
+			sb.WriteByte(b)
+			offsets = append(offsets, i)
+		} else {
+			sb.WriteString(expr[i : i+size])
+			for k := 0; k < size; k++ {
+				offsets = append(offsets, i+k)
+			}
+		}
+		i += size
+	}
+	offsets = append(offsets, len(expr))
+	return sb.String(), offsets
+}
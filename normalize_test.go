@@ -0,0 +1,97 @@
+package gonedice
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"１ｄ２０＋５":       "1d20+5",
+		"（2d6）×2":      "(2d6)*2",
+		"10÷3":         "10/3",
+		"[1，2，3]ｋｈ2":   "[1,2,3]kh2",
+		"【1、2】sp1":     "[1,2]sp1",
+		"1？2：3":        "1?2:3",
+		"1d6　＞＝　4":     "1d6 >= 4",
+		"{ＳＴＲ}＋１":      "{ＳＴＲ}+1",
+		"\"１ｄ６\"ｌｐ2":   "\"１ｄ６\"lp2",
+		"“x{i}y”ｌｐ2":   "\"x{i}y\"lp2",
+		"\"a\\\"１\"＋1": "\"a\\\"１\"+1",
+	}
+	for in, want := range cases {
+		if got := Normalize(in); got != want {
+			t.Fatalf("Normalize(%q): expected %q got %q", in, want, got)
+		}
+	}
+}
+
+func TestWithNormalize(t *testing.T) {
+	cases := map[string]int{
+		"１ｄ１＋５":      6,
+		"（2＋3）×2":    10,
+		"10÷3":       3,
+		"[1，5，3]ｋｈ2": 8,
+		"1？2：3":      2,
+		"{力量}＋１":     4,
+	}
+	vt := map[string]int{"力量": 3}
+	for expr, want := range cases {
+		p, err := Compile(expr, WithNormalize(), WithValueTable(vt))
+		if err != nil {
+			t.Fatalf("%q: unexpected error %v", expr, err)
+		}
+		if res := p.Roll(); res.Error != "" || res.Value != want {
+			t.Fatalf("%q: expected %d got %d (%v)", expr, want, res.Value, res.Err)
+		}
+		if p.String() != expr {
+			t.Fatalf("%q: Program should keep the original text, got %q", expr, p.String())
+		}
+	}
+
+	// without the option full-width input is still rejected
+	if _, err := Compile("１ｄ２０"); err == nil {
+		t.Fatalf("full-width input should be rejected by default")
+	}
+
+	r := New("（1d1）×3", nil, WithNormalize())
+	r.Roll()
+	if res := r.Result(); res.Value != 3 {
+		t.Fatalf("RD should normalize: %v %v", res.Value, res.Err)
+	}
+	r.Normalize = false
+	r.Roll()
+	if res := r.Result(); res.Error != ErrInputRawInvalid {
+		t.Fatalf("turning Normalize off should recompile: %v", res.Error)
+	}
+	if res := NewRoller(WithNormalize()).Roll("２ｄ１"); res.Value != 2 {
+		t.Fatalf("Roller should normalize: %v %v", res.Value, res.Err)
+	}
+}
+
+func TestNormalizeErrorSpan(t *testing.T) {
+	// parse errors point at the original full-width text
+	_, err := Compile("１＋＃２", WithNormalize())
+	perr, ok := err.(*Error)
+	if !ok || perr.Code != ErrInputRawInvalid || perr.Token != "＃" || perr.Offset != 6 || perr.End != 9 {
+		t.Fatalf("unexpected error span: %#v", err)
+	}
+	if start, end := perr.RuneSpan(); start != 2 || end != 3 {
+		t.Fatalf("unexpected rune span [%d,%d)", start, end)
+	}
+	if perr.Msg != "unexpected char '＃'" || !strings.Contains(perr.Error(), `'＃' at 2 near "＃"`) {
+		t.Fatalf("message should name the original character: %v", perr)
+	}
+	if _, err := Compile("１ｄ６＃", WithNormalize(), WithLocale("zh-CN")); err == nil || !strings.Contains(err.Error(), "无法识别的字符 '＃'") {
+		t.Fatalf("localized message should name the original character: %v", err)
+	}
+
+	// evaluation errors as well
+	res := MustCompile("（1＋2）／０", WithNormalize()).Roll()
+	if res.Error != ErrNodeRightValInvalid || res.Err.Token != "０" || res.Err.Op != "/" {
+		t.Fatalf("unexpected evaluation error: %+v", res.Err)
+	}
+	if _, err := Compile("（1＋", WithNormalize()); err == nil || err.(*Error).Offset != len("（1＋") {
+		t.Fatalf("missing operand should point at the end of the input: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package gonedice
+
+import "time"
+
+// Option 配置表达式的求值，可用于 New、Compile、Program.Roll 与 Distribution
+type Option func(*config)
+
+// config 保存由 Option 设置的求值参数
+type config struct {
+	valueTable   map[string]int
+	rng          Source
+	defaultFaces int
+	sortedKeep   bool
+	strictVars   bool
+	limits       Limits
+	record       bool
+	trace        bool
+	renderer     Renderer
+	locale       *Locale
+	normalize    bool
+}
+
+// newConfig 在默认参数上依次应用 opts，后面的选项覆盖前面的选项
+func newConfig(opts []Option) config {
+	cfg := config{defaultFaces: 100}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// source 返回配置的随机数来源，未配置时使用以当前时间为种子的 math/rand
+func (c config) source() Source {
+	if c.rng != nil {
+		return c.rng
+	}
+	return NewMathSource(time.Now().UnixNano())
+}
+
+// WithValueTable 设置求值使用的变量表
+// 赋值运算会把临时变量写回该表（键为 Tn）
+func WithValueTable(vt map[string]int) Option {
+	return func(c *config) {
+		c.valueTable = vt
+	}
+}
+
+// WithRNG 设置求值使用的随机数来源，*rand.Rand 与 NewMathSource 等内置实现都可以使用
+func WithRNG(rng Source) Option {
+	return func(c *config) {
+		c.rng = rng
+	}
+}
+
+// WithSeed 使用以 seed 为种子的 math/rand 作为随机数来源，等同于 WithRNG(NewMathSource(seed))
+// 在 Compile 中使用时，每次求值都会从同一个种子重新开始，因此结果相同
+func WithSeed(seed int64) Option {
+	return func(c *config) {
+		c.rng = NewMathSource(seed)
+	}
+}
+
+// WithDefaultFaces 设置省略面数时 d 使用的默认面数，默认为 100
+func WithDefaultFaces(faces int) Option {
+	return func(c *config) {
+		c.defaultFaces = faces
+	}
+}
+
+// WithSortedKeep 让 k/q/kh/kl/dh/dl 按点数排序返回保留的元素（旧行为）
+// 默认情况下保留的元素保持掷出顺序
+func WithSortedKeep() Option {
+	return func(c *config) {
+		c.sortedKeep = true
+	}
+}
+
+// WithStrictVariables 要求临时变量 $t 在读取前已被赋值（或在变量表中有 Tn），否则报错
+// 默认情况下未赋值的临时变量读取为 0
+func WithStrictVariables() Option {
+	return func(c *config) {
+		c.strictVars = true
+	}
+}
+
+// WithLimits 设置资源限制，为 0 的字段使用 DefaultLimits 中的值
+func WithLimits(l Limits) Option {
+	return func(c *config) {
+		c.limits = l
+	}
+}
+
+// WithRecord 让 Result.Record 记录本次求值取得的全部随机数，可用 Replay 核对结果
+func WithRecord() Option {
+	return func(c *config) {
+		c.record = true
+	}
+}
+
+// WithTrace 让 Result.Trace 记录求值过程的步骤树，Trace.String 渲染为骰子机器人风格的描述
+func WithTrace() Option {
+	return func(c *config) {
+		c.trace = true
+	}
+}
+
+// WithRenderer 设置生成 Result.Detail 的渲染器，默认为 PlainRenderer
+// 设置渲染器时同时记录 Result.Trace，供 MarkdownRenderer 等渲染器使用
+func WithRenderer(r Renderer) Option {
+	return func(c *config) {
+		c.renderer = r
+	}
+}
+
+// WithLocale 按语言标签（如 "zh-CN"、"en-US"）选择错误信息与 Detail 标签使用的消息目录
+// 标签未注册时使用默认文本，可用 RegisterLocale 在运行时注册新的目录
+func WithLocale(tag string) Option {
+	return func(c *config) {
+		c.locale, _ = LookupLocale(tag)
+	}
+}
+
+// WithNormalize 在解析前把全角字母数字、全角运算符、中文标点与 ×、÷ 转换为 ASCII 字符，
+// 使中文输入法输入的 "１ｄ２０＋５"、"（2d6）×2" 可以直接求值，错误位置仍然指向原始输入
+func WithNormalize() Option {
+	return func(c *config) {
+		c.normalize = true
+	}
+}
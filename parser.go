@@ -0,0 +1,714 @@
+package gonedice
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tokenKind 表示词法标记的种类
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokTemp
+	tokVar
+	tokOp
+)
+
+// token 是词法分析产生的标记
+type token struct {
+	kind tokenKind
+	// text 标记内容：标识符为小写形式，字符串为去掉引号并处理转义后的内容
+	text string
+	// num 数字标记的值
+	num int
+	// pos, end 标记在原始表达式中的字节区间
+	pos int
+	end int
+}
+
+// isDigit 判断字符是否为数字
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// isLetter 判断字符是否为 ASCII 字母
+func isLetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+// tokenize 将表达式分割为标记：数字、运算符、括号、字符串、变量等
+func tokenize(s string) ([]token, *Error) {
+	var toks []token
+	i := 0
+
+	for i < len(s) {
+		c := s[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			i++
+			continue
+		}
+
+		// 支持双引号字符串字面量
+		if c == '"' {
+			j := i + 1
+			var sb strings.Builder
+			for j < len(s) {
+				if s[j] == '\\' && j+1 < len(s) {
+					sb.WriteByte(s[j+1])
+					j += 2
+					continue
+				}
+				if s[j] == '"' {
+					break
+				}
+				sb.WriteByte(s[j])
+				j++
+			}
+			if j >= len(s) || s[j] != '"' {
+				return nil, newError(ErrInputRawInvalid, s, i, len(s), "", "unterminated string literal")
+			}
+			toks = append(toks, token{kind: tokString, text: sb.String(), pos: i, end: j + 1})
+			i = j + 1
+			continue
+		}
+
+		if isDigit(c) {
+			j := i + 1
+			for j < len(s) && isDigit(s[j]) {
+				j++
+			}
+			n, err := strconv.Atoi(s[i:j])
+			if err != nil {
+				return nil, newError(ErrInputRawInvalid, s, i, j, "", "number out of range")
+			}
+			toks = append(toks, token{kind: tokNumber, text: s[i:j], num: n, pos: i, end: j})
+			i = j
+			continue
+		}
+
+		// 变量引用 {NAME}
+		if c == '{' {
+			j := strings.IndexByte(s[i+1:], '}')
+			if j < 0 {
+				return nil, newError(ErrInputRawInvalid, s, i, len(s), "", "unterminated variable reference")
+			}
+			if j == 0 {
+				return nil, newError(ErrInputRawInvalid, s, i, i+2, "", "empty variable name")
+			}
+			name := s[i+1 : i+1+j]
+			toks = append(toks, token{kind: tokVar, text: strings.ToUpper(name), pos: i, end: i + j + 2})
+			i += j + 2
+			continue
+		}
+
+		// 双字符运算符：比较与逻辑运算
+		if i+1 < len(s) {
+			switch op := s[i : i+2]; op {
+			case ">=", "<=", "==", "!=", "&&", "||":
+				toks = append(toks, token{kind: tokOp, text: op, pos: i, end: i + 2})
+				i += 2
+				continue
+			}
+		}
+
+		// 单字符运算符和标点符号
+		if strings.IndexByte("+-*/^(),?:=<>&|%[]!", c) >= 0 {
+			toks = append(toks, token{kind: tokOp, text: string(c), pos: i, end: i + 1})
+			i++
+			continue
+		}
+
+		// 临时变量如 $t、$t2
+		if c == '$' {
+			j := i + 1
+			for j < len(s) && (isLetter(s[j]) || isDigit(s[j])) {
+				j++
+			}
+			toks = append(toks, token{kind: tokTemp, text: strings.ToLower(s[i:j]), pos: i, end: j})
+			i = j
+			continue
+		}
+
+		// 字母运算符如 'd'、'kh'
+		if isLetter(c) {
+			j := i + 1
+			for j < len(s) && isLetter(s[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: tokIdent, text: strings.ToLower(s[i:j]), pos: i, end: j})
+			i = j
+			continue
+		}
+
+		ch, size := utf8.DecodeRuneInString(s[i:])
+		return nil, newError(ErrInputRawInvalid, s, i, i+size, "", "unexpected char %q", ch)
+	}
+
+	toks = append(toks, token{kind: tokEOF, pos: len(s), end: len(s)})
+	return toks, nil
+}
+
+// tokenizeNormalized 对 normalize 转换后的表达式分词，并把标记与错误的区间映射回原始表达式
+func tokenizeNormalized(src string) ([]token, *Error) {
+	s, offsets := normalize(src)
+	toks, err := tokenize(s)
+	if err != nil {
+		start, end := offsets[err.Offset], offsets[err.End]
+		// 错误参数中的字符（如 unexpected char）取自原始表达式，使信息与区间一致
+		args := append([]any(nil), err.args...)
+		for i, a := range args {
+			if _, ok := a.(rune); ok {
+				args[i], _ = utf8.DecodeRuneInString(src[start:])
+			}
+		}
+		return nil, newError(err.Code, src, start, end, err.Op, err.format, args...)
+	}
+	for i := range toks {
+		toks[i].pos, toks[i].end = offsets[toks[i].pos], offsets[toks[i].end]
+	}
+	return toks, nil
+}
+
+// 二元运算符优先级映射，数值越大结合越紧密
+// 三元运算符 ?: 的优先级低于所有二元运算符，单独处理
+// 从低到高依次为：逻辑或、逻辑与、比较、按位运算、加减、乘除、前缀运算、乘方、选择类运算、掷骰类运算、赋值
+var prec = map[string]int{
+	"||":  1,
+	"&&":  2,
+	"<":   3,
+	">":   3,
+	"<=":  3,
+	">=":  3,
+	"==":  3,
+	"!=":  3,
+	"|":   4,
+	"&":   4,
+	"+":   5,
+	"-":   5,
+	"*":   6,
+	"/":   6,
+	"^":   8,
+	"d":   10,
+	"df":  10,
+	"k":   9,
+	"q":   9,
+	"a":   10,
+	"c":   10,
+	"b":   10,
+	"p":   10,
+	"f":   10,
+	"kh":  9,
+	"kl":  9,
+	"dh":  9,
+	"dl":  9,
+	"min": 9,
+	"max": 9,
+	"sp":  9,
+	"tp":  9,
+	"lp":  9,
+	"=":   12,
+}
+
+// precUnary 前缀运算符（逻辑非 !、负号 -、正号 +）的优先级：低于乘方与掷骰，高于乘除
+// 因此 -2^2 为 -4，-1d6 作用于整个掷骰结果，!1+1 等同于 (!1)+1
+const precUnary = 7
+
+// defaultLeft 缺少左操作数时各掷骰类运算符使用的默认值
+var defaultLeft = map[string]int{
+	"d":  1,
+	"b":  1,
+	"p":  1,
+	"a":  1,
+	"c":  1,
+	"f":  4,
+	"df": 4,
+}
+
+// defaultRight 缺少右操作数时各掷骰类运算符使用的默认值
+// d 的默认面数在求值时由 DefaultFaces 决定，因此不在此表中
+var defaultRight = map[string]int{
+	"b":  1,
+	"p":  1,
+	"f":  3,
+	"df": 3,
+}
+
+// isLeftAssoc 判断运算符是否为左结合
+func isLeftAssoc(op string) bool {
+	if op == "^" || op == "=" {
+		return false
+	}
+	return true
+}
+
+// isOperator 判断标记是否为二元运算符
+func isOperator(t token) bool {
+	if t.kind != tokOp && t.kind != tokIdent {
+		return false
+	}
+	_, ok := prec[t.text]
+	return ok
+}
+
+// parser 是基于优先级爬升的递归下降解析器
+type parser struct {
+	src  string
+	toks []token
+	i    int
+	// op 最近一次消耗的运算符，用于错误信息
+	op string
+	// depth 当前的嵌套深度，maxDepth 为其上限（负数表示不限制）
+	depth, maxDepth int
+}
+
+// parse 将表达式解析为语法树，lim 限制表达式的长度与嵌套深度
+// norm 为 true 时先用 normalize 转换全角字符与中文标点，标记与错误的位置仍然指向原始表达式
+func parse(src string, lim Limits, norm bool) (node, *Error) {
+	if exceeds(len(src), lim.MaxExprLen) {
+		return nil, newError(ErrLimitExceeded, src, lim.MaxExprLen, len(src), "", "expression too long")
+	}
+	var toks []token
+	var err *Error
+	if norm {
+		toks, err = tokenizeNormalized(src)
+	} else {
+		toks, err = tokenize(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, toks: toks, maxDepth: lim.MaxDepth}
+	n, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		if isPunct(t, ")") {
+			return nil, p.errorAt(ErrInputNodeOperationInvalid, t, "unmatched ')'")
+		}
+		return nil, p.errorAt(ErrInputNodeOperationInvalid, t, "unexpected token")
+	}
+	return n, nil
+}
+
+// errorAt 构造指向标记 t 的解析错误
+func (p *parser) errorAt(code ErrorType, t token, msg string, args ...any) *Error {
+	return newError(code, p.src, t.pos, t.end, p.op, msg, args...)
+}
+
+// missingOperand 构造缺少操作数的错误，指向当前位置
+func (p *parser) missingOperand() *Error {
+	return p.errorAt(ErrNodeStackEmpty, p.peek(), "missing operand")
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// isPunct 判断标记是否为指定的标点符号
+func isPunct(t token, s string) bool {
+	return t.kind == tokOp && t.text == s
+}
+
+// operandMissing 判断当前位置是否缺少操作数：
+// 即下一个标记是运算符、右括号、分隔符或已到达结尾
+func (p *parser) operandMissing() bool {
+	t := p.peek()
+	if t.kind == tokEOF || isOperator(t) || isDiceModifier(t) {
+		return true
+	}
+	if t.kind == tokOp {
+		switch t.text {
+		case ")", "]", ",", "?", ":":
+			return true
+		}
+	}
+	return false
+}
+
+// parseExpr 解析三元表达式：cond ? then : els，右结合且优先级最低
+func (p *parser) parseExpr() (node, *Error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !isPunct(p.peek(), "?") {
+		return cond, nil
+	}
+	qt := p.next()
+	p.op = "?"
+	then, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if !isPunct(p.peek(), ":") {
+		return nil, p.errorAt(ErrInputNodeOperationInvalid, qt, "missing ':' in ternary")
+	}
+	p.next()
+	els, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	start, _ := cond.span()
+	_, end := els.span()
+	return &ternaryNode{pos: pos{start, end}, cond: cond, then: then, els: els}, nil
+}
+
+// parseBinary 使用优先级爬升解析优先级不低于 minPrec 的二元运算
+func (p *parser) parseBinary(minPrec int) (node, *Error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if exceeds(p.depth, p.maxDepth) {
+		return nil, p.errorAt(ErrLimitExceeded, p.peek(), "expression nested too deeply")
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if !isOperator(t) || prec[t.text] < minPrec {
+			return left, nil
+		}
+		p.next()
+		op := t.text
+		p.op = op
+		pr := prec[op]
+		nextMin := pr + 1
+		if !isLeftAssoc(op) {
+			nextMin = pr
+		}
+		start, _ := left.span()
+
+		switch op {
+		case "d":
+			var sides node
+			if isPunct(p.peek(), "%") {
+				pt := p.next()
+				sides = &numberNode{pos: pos{pt.pos, pt.end}, v: 100}
+			} else if !p.operandMissing() {
+				// 面数中不包含赋值，需要时加括号，如 2d($t=6)
+				sides, err = p.parseBinary(prec["="] + 1)
+				if err != nil {
+					return nil, err
+				}
+			}
+			end := t.end
+			if sides != nil {
+				_, end = sides.span()
+			}
+			dn := &diceNode{pos: pos{start, end}, times: left, sides: sides}
+			if err := p.parseDiceModifiers(dn); err != nil {
+				return nil, err
+			}
+			if err := p.parseSuccess(dn); err != nil {
+				return nil, err
+			}
+			left = dn
+		case "a", "c":
+			threshold, err := p.parseBinary(nextMin)
+			if err != nil {
+				return nil, err
+			}
+			_, end := threshold.span()
+			var faces node
+			// <left> a <threshold> m <faces>：仅当阈值与面数都是字面量时识别
+			if isLiteral(threshold) && p.peek().kind == tokIdent && p.peek().text == "m" && p.i+1 < len(p.toks) {
+				ft := p.toks[p.i+1]
+				if ft.kind == tokNumber || ft.kind == tokVar {
+					p.next()
+					faces, _ = p.parseOperand()
+					_, end = faces.span()
+				}
+			}
+			left = &chainNode{pos: pos{start, end}, op: op, times: left, threshold: threshold, faces: faces}
+		default:
+			if op == "df" {
+				op = "f"
+			}
+			var right node
+			if dv, ok := defaultRight[t.text]; ok && p.operandMissing() {
+				right = &numberNode{pos: pos{t.end, t.end}, v: dv}
+			} else {
+				right, err = p.parseBinary(nextMin)
+				if err != nil {
+					return nil, err
+				}
+			}
+			_, end := right.span()
+			left = &binaryNode{pos: pos{start, end}, op: op, left: left, right: right}
+		}
+	}
+}
+
+// isDiceModifier 判断标记是否为掷骰修饰的起始：!、r、ro、cs
+func isDiceModifier(t token) bool {
+	return isPunct(t, "!") || (t.kind == tokIdent && (t.text == "r" || t.text == "ro" || t.text == "cs"))
+}
+
+// signNext 判断下一个标记是否为可以作为前缀运算的正负号
+func (p *parser) signNext() bool {
+	t := p.peek()
+	return isPunct(t, "-") || isPunct(t, "+")
+}
+
+// parseDiceModifiers 解析紧跟在掷骰项之后的修饰，修饰可以任意顺序出现，每种最多一个：
+//   - 爆炸 !、复利 !!、穿透 !p，可带比较点或紧跟的单个数字（等同于 =N），如 3d6!>5、3d6!6
+//   - 重投 r<cond>、只重投一次 ro<cond>，条件为比较点或单个数字（等同于 =N）
+//
+// != 始终是不等比较（2d6!=7 比较的是总和），掷出 N 时爆炸写作 3d6!N
+// 修饰会扩展掷骰节点的区间
+func (p *parser) parseDiceModifiers(dn *diceNode) *Error {
+	for {
+		t := p.peek()
+		if t.kind == tokIdent && (t.text == "r" || t.text == "ro") {
+			if dn.reroll != nil {
+				return p.errorAt(ErrInputNodeOperationInvalid, t, "duplicate reroll modifier")
+			}
+			p.next()
+			p.op = t.text
+			cmp, err := p.parseComparePoint(true)
+			if err != nil {
+				return err
+			}
+			dn.reroll = &rerollMod{pos: pos{t.pos, cmp.end}, once: t.text == "ro", cmp: cmp}
+			dn.end = cmp.end
+			continue
+		}
+		if !isPunct(t, "!") {
+			return nil
+		}
+		if dn.explode != nil {
+			return p.errorAt(ErrInputNodeOperationInvalid, t, "duplicate explosion modifier")
+		}
+		p.next()
+		p.op = "!"
+		mod := &explodeMod{pos: pos{t.pos, t.end}, kind: "!"}
+		if nt := p.peek(); nt.pos == t.end && isPunct(nt, "!") {
+			p.next()
+			mod.kind, mod.end = "!!", nt.end
+		} else if nt.pos == t.end && nt.kind == tokIdent && nt.text == "p" {
+			p.next()
+			mod.kind, mod.end = "!p", nt.end
+		}
+		p.op = mod.kind
+		var cmp *comparePoint
+		var err *Error
+		// 紧跟在修饰之后的数字或变量是 =N 比较点，如 3d6!6、d6!!{X}
+		if nt := p.peek(); nt.pos == mod.end && (nt.kind == tokNumber || nt.kind == tokVar) {
+			cmp, err = p.parseComparePoint(true)
+		} else {
+			cmp, err = p.parseComparePoint(false)
+		}
+		if err != nil {
+			return err
+		}
+		if cmp != nil {
+			mod.cmp = cmp
+			mod.end = cmp.end
+		}
+		dn.explode = mod
+		dn.end = mod.end
+	}
+}
+
+// parseSuccess 解析紧跟在骰池之后的成功计数 cs<cond>，如 10d10cs>=8、10d10cs10
+// 之后可以任意顺序跟随失败扣除 f<cond> 与双倍成功 ds<cond>，条件可以是单个数字
+// 骰池之后的 >、<、>=、<=、==、!= 都是比较运算，比较的是骰池的总和
+func (p *parser) parseSuccess(dn *diceNode) *Error {
+	t := p.peek()
+	if t.kind != tokIdent || t.text != "cs" {
+		return nil
+	}
+	p.next()
+	p.op = t.text
+	target, err := p.parseComparePoint(true)
+	if err != nil {
+		return err
+	}
+	mod := &successMod{pos: pos{t.pos, target.end}, target: target}
+	for {
+		t := p.peek()
+		if t.kind != tokIdent || (t.text != "f" && t.text != "ds") {
+			break
+		}
+		slot := &mod.fail
+		if t.text == "ds" {
+			slot = &mod.double
+		}
+		if *slot != nil {
+			return p.errorAt(ErrInputNodeOperationInvalid, t, "duplicate success modifier")
+		}
+		p.next()
+		p.op = t.text
+		cmp, err := p.parseComparePoint(true)
+		if err != nil {
+			return err
+		}
+		*slot = cmp
+		mod.end = cmp.end
+	}
+	dn.success = mod
+	dn.end = mod.end
+	return nil
+}
+
+// parseComparePoint 解析比较点 >N、<N、>=N、<=N、=N
+// 比较值只能是单个操作数，如数字、变量或括号子表达式
+// bare 为 true 时比较点是必需的，且允许省略运算符（N 等同于 =N）；否则没有比较点时返回 nil
+func (p *parser) parseComparePoint(bare bool) (*comparePoint, *Error) {
+	t := p.peek()
+	if !isPunct(t, ">") && !isPunct(t, "<") && !isPunct(t, ">=") && !isPunct(t, "<=") && !isPunct(t, "=") {
+		if !bare {
+			return nil, nil
+		}
+		if p.operandMissing() && !p.signNext() {
+			return nil, p.missingOperand()
+		}
+		v, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		start, end := v.span()
+		return &comparePoint{pos: pos{start, end}, op: "=", value: v}, nil
+	}
+	p.next()
+	return p.finishComparePoint(&comparePoint{pos: pos{t.pos, t.end}, op: t.text})
+}
+
+// finishComparePoint 在已消耗比较运算符之后解析比较值
+func (p *parser) finishComparePoint(cp *comparePoint) (*comparePoint, *Error) {
+	if p.operandMissing() && !p.signNext() {
+		return nil, p.missingOperand()
+	}
+	v, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	cp.value = v
+	_, cp.end = v.span()
+	return cp, nil
+}
+
+// isLiteral 判断节点是否为数字字面量或变量引用
+func isLiteral(n node) bool {
+	switch n.(type) {
+	case *numberNode, *varNode:
+		return true
+	}
+	return false
+}
+
+// parseOperand 解析一个操作数：数字、字符串、多元组、变量、括号子表达式、前缀运算
+// 若遇到缺少左操作数的掷骰类运算符（如 d6），则补全其默认左值
+func (p *parser) parseOperand() (node, *Error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.next()
+		return &numberNode{pos: pos{t.pos, t.end}, v: t.num}, nil
+	case tokString:
+		p.next()
+		return &stringNode{pos: pos{t.pos, t.end}, s: t.text}, nil
+	case tokVar:
+		p.next()
+		return &varNode{pos: pos{t.pos, t.end}, name: t.text}, nil
+	case tokTemp:
+		p.next()
+		idx := 1
+		if len(t.text) > 2 {
+			if n, err := strconv.Atoi(t.text[2:]); err == nil {
+				idx = n
+			}
+		}
+		return &tempNode{pos: pos{t.pos, t.end}, index: idx}, nil
+	case tokIdent:
+		if dv, ok := defaultLeft[t.text]; ok {
+			// 不消耗运算符本身，交由 parseBinary 继续处理
+			return &numberNode{pos: pos{t.pos, t.pos}, v: dv}, nil
+		}
+		if isOperator(t) {
+			return nil, p.missingOperand()
+		}
+		return nil, p.errorAt(ErrInputNodeOperationInvalid, t, "unknown identifier")
+	case tokOp:
+		switch t.text {
+		case "(":
+			p.next()
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			ct := p.peek()
+			if ct.kind == tokEOF {
+				return nil, p.errorAt(ErrInputRawInvalid, t, "unclosed '('")
+			}
+			if !isPunct(ct, ")") {
+				return nil, p.errorAt(ErrInputNodeOperationInvalid, ct, "unexpected token")
+			}
+			p.next()
+			return &groupNode{pos: pos{t.pos, ct.end}, x: x}, nil
+		case "[":
+			return p.parseTuple()
+		case "!", "-", "+":
+			p.next()
+			p.op = t.text
+			x, err := p.parseBinary(precUnary + 1)
+			if err != nil {
+				return nil, err
+			}
+			_, end := x.span()
+			return &unaryNode{pos: pos{t.pos, end}, op: t.text, x: x}, nil
+		case "%":
+			return nil, p.errorAt(ErrInputNodeOperationInvalid, t, "unexpected '%c'", '%')
+		}
+	}
+	return nil, p.missingOperand()
+}
+
+// parseTuple 解析多元组字面量 [e1, e2, ...]，元素可以是任意表达式
+func (p *parser) parseTuple() (node, *Error) {
+	open := p.next()
+	tn := &tupleNode{}
+	for {
+		t := p.peek()
+		if isPunct(t, "]") {
+			p.next()
+			tn.pos = pos{open.pos, t.end}
+			return tn, nil
+		}
+		if isPunct(t, ",") {
+			// 空元素被忽略
+			p.next()
+			continue
+		}
+		if t.kind == tokEOF {
+			return nil, p.errorAt(ErrInputRawInvalid, open, "unterminated bracketed tuple")
+		}
+		el, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		tn.elems = append(tn.elems, el)
+		if t := p.peek(); !isPunct(t, ",") && !isPunct(t, "]") {
+			if t.kind == tokEOF {
+				return nil, p.errorAt(ErrInputRawInvalid, open, "unterminated bracketed tuple")
+			}
+			return nil, p.errorAt(ErrInputNodeOperationInvalid, t, "unexpected token in tuple")
+		}
+	}
+}
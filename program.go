@@ -0,0 +1,94 @@
+package gonedice
+
+import (
+	"context"
+	"maps"
+)
+
+// Program 是编译后的掷骰表达式
+// 表达式只解析一次，之后可以使用不同的变量表或随机数生成器反复求值
+type Program struct {
+	// expr 原始表达式
+	expr string
+	// root 语法树根节点
+	root node
+	// opts 编译时给出的默认选项，每次求值时先于调用者的选项应用
+	opts []Option
+	// normalize 解析时是否转换了全角字符与中文标点
+	normalize bool
+	// limits 解析时使用的资源限制（已替换默认值），MaxExprLen 与 MaxDepth 在解析时检查
+	limits Limits
+}
+
+// Compile 将表达式解析为可重复求值的 Program
+// opts 作为每次求值的默认选项，Roll 与 Distribution 传入的选项可以覆盖它们
+// 解析失败时返回的 error 为 *Error，包含出错位置
+func Compile(expr string, opts ...Option) (*Program, error) {
+	cfg := newConfig(opts)
+	lim := cfg.limits.resolve()
+	root, err := parse(expr, lim, cfg.normalize)
+	if err != nil {
+		return nil, err.localize(cfg.locale)
+	}
+	return &Program{expr: expr, root: root, opts: opts, normalize: cfg.normalize, limits: lim}, nil
+}
+
+// MustCompile 与 Compile 相同，但解析失败时 panic
+// 适用于在包级变量中初始化固定的宏表达式
+func MustCompile(expr string, opts ...Option) *Program {
+	p, err := Compile(expr, opts...)
+	if err != nil {
+		panic("gonedice: Compile(" + expr + "): " + err.Error())
+	}
+	return p
+}
+
+// String 返回原始表达式
+func (p *Program) String() string {
+	return p.expr
+}
+
+// Roll 对编译后的表达式求值并返回结果
+// 每次调用使用独立的临时变量表
+func (p *Program) Roll(opts ...Option) Result {
+	return p.RollContext(context.Background(), opts...)
+}
+
+// RollContext 与 Roll 相同，但 ctx 被取消或超时后求值会尽快中止并返回 ErrCancelled
+func (p *Program) RollContext(ctx context.Context, opts ...Option) Result {
+	cfg := p.config(opts)
+	cfg.rng = cfg.source()
+	e := newEvaluator(ctx, cfg, p.expr)
+	return e.run(p.root)
+}
+
+// config 依次应用编译时与求值时的选项
+func (p *Program) config(opts []Option) config {
+	all := append(append([]Option(nil), p.opts...), opts...)
+	return newConfig(all)
+}
+
+// newEvaluator 按 cfg 构造一次求值使用的求值器，cfg.rng 必须已经确定
+// 变量表在此复制：赋值写入的 Tn 不会修改调用者的表，也不会被下一次求值看到
+func newEvaluator(ctx context.Context, cfg config, src string) *evaluator {
+	e := &evaluator{
+		rng:          cfg.rng,
+		vt:           maps.Clone(cfg.valueTable),
+		temp:         map[int]int{},
+		defaultFaces: cfg.defaultFaces,
+		sortedKeep:   cfg.sortedKeep,
+		strictVars:   cfg.strictVars,
+		lim:          cfg.limits.resolve(),
+		budget:       &budget{},
+		ctx:          ctx,
+		src:          src,
+		tracing:      cfg.trace || cfg.renderer != nil,
+		renderer:     cfg.renderer,
+		locale:       cfg.locale,
+		normalize:    cfg.normalize,
+	}
+	if cfg.record {
+		e.record = newRecord(cfg.rng)
+	}
+	return e
+}
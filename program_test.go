@@ -0,0 +1,199 @@
+package gonedice
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestCompileRollMatchesRD(t *testing.T) {
+	exprs := []string{"1+2*3", "4d6kh3", "3a5m6", "1b3", "(1?($t1=5):($t1=6))+$t1", "\"x{i}y\"lp2", "[4,2,6]kh2"}
+	for _, expr := range exprs {
+		p, err := Compile(expr)
+		if err != nil {
+			t.Fatalf("compile %q: %v", expr, err)
+		}
+		res := p.Roll(WithRNG(rand.New(rand.NewSource(7))))
+
+		r := New(expr, nil)
+		r.rng = rand.New(rand.NewSource(7))
+		r.Roll()
+		want := r.Result()
+
+		if res.Error != want.Error || res.Value != want.Value || res.Detail != want.Detail {
+			t.Fatalf("%q: program result %+v differs from RD result %+v", expr, res, want)
+		}
+	}
+}
+
+func TestProgramRollRepeatedly(t *testing.T) {
+	p, err := Compile("{STR}+$t")
+	if err != nil {
+		t.Fatalf("unexpected compile error: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		res := p.Roll(WithValueTable(map[string]int{"STR": i}))
+		if res.Error != "" {
+			t.Fatalf("unexpected error: %v", res.Error)
+		}
+		if res.Value != i {
+			t.Fatalf("roll %d expected %d got %d", i, i, res.Value)
+		}
+	}
+
+	// temp variables must not leak between rolls
+	p2 := MustCompile("$t=$t+1")
+	for i := 0; i < 3; i++ {
+		if v := p2.Roll().Value; v != 1 {
+			t.Fatalf("temp leaked between rolls: got %d", v)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := map[string]ErrorType{
+		"1+":      ErrNodeStackEmpty,
+		"(1+2":    ErrInputRawInvalid,
+		"1?2":     ErrInputNodeOperationInvalid,
+		"abc":     ErrInputNodeOperationInvalid,
+		"\"abc":   ErrInputRawInvalid,
+		"1#2":     ErrInputRawInvalid,
+		"[1,2":    ErrInputRawInvalid,
+		"1 2":     ErrInputNodeOperationInvalid,
+		"1d6)":    ErrInputNodeOperationInvalid,
+		"kh3":     ErrNodeStackEmpty,
+		"2*(3+4)": "",
+	}
+	for expr, want := range cases {
+		_, err := Compile(expr)
+		if want == "" {
+			if err != nil {
+				t.Fatalf("%q: unexpected error %v", expr, err)
+			}
+			continue
+		}
+		perr, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("%q: expected *Error got %T", expr, err)
+		}
+		if perr.Code != want {
+			t.Fatalf("%q: expected %v got %v", expr, want, perr.Code)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	// WithSeed on New and Roll gives the same dice as an explicit source
+	r := New("4d6", nil, WithSeed(3))
+	r.Roll()
+	if got, want := r.Result().Detail, MustCompile("4d6").Roll(WithRNG(NewMathSource(3))).Detail; got != want {
+		t.Fatalf("WithSeed mismatch: %q vs %q", got, want)
+	}
+
+	// options given to Compile are defaults, options given to Roll override them
+	p := MustCompile("d", WithDefaultFaces(6), WithSeed(1))
+	if res := p.Roll(); res.Max != 6 || res.Detail != p.Roll().Detail {
+		t.Fatalf("compile options not applied: %q", res.Detail)
+	}
+	if res := p.Roll(WithDefaultFaces(20)); res.Max != 20 {
+		t.Fatalf("roll options should override compile options: max %d", res.Max)
+	}
+	if d, err := p.Distribution(); err != nil || d.Max() != 6 {
+		t.Fatalf("Distribution should use compile options: %v", err)
+	}
+	if r := New("d", nil, WithDefaultFaces(8)); r.DefaultFaces != 8 {
+		t.Fatalf("New should apply WithDefaultFaces, got %d", r.DefaultFaces)
+	}
+
+	// WithValueTable overrides the positional table of New
+	r = New("{STR}", map[string]int{"STR": 1}, WithValueTable(map[string]int{"STR": 2}))
+	r.Roll()
+	if r.Result().Value != 2 {
+		t.Fatalf("WithValueTable should override the positional table, got %d", r.Result().Value)
+	}
+}
+
+func TestStrictVariables(t *testing.T) {
+	p := MustCompile("$t1+1", WithStrictVariables())
+	if res := p.Roll(); res.Error != ErrInputChildParaInvalid || res.Err == nil || res.Err.Token != "$t1" {
+		t.Fatalf("unassigned temp should be rejected: %+v", res)
+	}
+	if res := p.Roll(WithValueTable(map[string]int{"T1": 4})); res.Error != "" || res.Value != 5 {
+		t.Fatalf("T1 in the value table should count as assigned: %+v", res)
+	}
+	if res := MustCompile("$t1+1").Roll(); res.Error != "" || res.Value != 1 {
+		t.Fatalf("non-strict mode reads unassigned temps as 0: %+v", res)
+	}
+
+	for _, expr := range []string{"$t1=2", "($t1)=2", "($t1=2)+$t1", "1?($t1=3):$t1"} {
+		r := New(expr, nil, WithStrictVariables())
+		r.Roll()
+		if res := r.Result(); res.Error != "" {
+			t.Fatalf("%q: assignment should work in strict mode: %v", expr, res.Error)
+		}
+	}
+}
+
+// cancelSource cancels its context after a fixed number of draws
+type cancelSource struct {
+	n      int
+	after  int
+	cancel context.CancelFunc
+}
+
+func (s *cancelSource) Intn(n int) int {
+	s.n++
+	if s.n == s.after {
+		s.cancel()
+	}
+	return s.n % n
+}
+
+func TestRollContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res := MustCompile("1d6").RollContext(ctx)
+	if res.Error != ErrCancelled || !errors.Is(res.Err, context.Canceled) {
+		t.Fatalf("cancelled context should abort: %v %v", res.Error, res.Err)
+	}
+
+	// cancellation is noticed inside a long pool, not only between nodes
+	for _, expr := range []string{"10000d6", "10000d6!", "9000a5m10", "2^1000000"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		src := &cancelSource{after: 2000, cancel: cancel}
+		p := MustCompile(expr, WithRNG(src))
+		if expr == "2^1000000" {
+			cancel()
+		}
+		res := p.RollContext(ctx, WithLimits(Limits{MaxOps: -1}))
+		if res.Error != ErrCancelled {
+			t.Fatalf("%q: expected cancellation got %v", expr, res.Error)
+		}
+		if src.n > 4000 {
+			t.Fatalf("%q: kept rolling after cancellation: %d draws", expr, src.n)
+		}
+	}
+
+	// cancellation inside a string element is not mistaken for a non-numeric element
+	ctx, cancel = context.WithCancel(context.Background())
+	res = MustCompile(`["1d6+1d6"]kh1`, WithRNG(&cancelSource{after: 1, cancel: cancel})).RollContext(ctx)
+	if res.Error != ErrCancelled || !errors.Is(res.Err, context.Canceled) {
+		t.Fatalf("cancellation in a string element should abort: %v %v", res.Error, res.Err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	r := New("4d6kh3", nil)
+	r.RollContext(ctx)
+	if res := r.Result(); res.Error != "" {
+		t.Fatalf("unexpected error %v", res.Err)
+	}
+	ctx, cancel2 := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel2()
+	r.RollContext(ctx)
+	if res := r.Result(); res.Error != ErrCancelled || !errors.Is(res.Err, context.DeadlineExceeded) {
+		t.Fatalf("expired deadline should abort: %v", res.Err)
+	}
+}
@@ -0,0 +1,108 @@
+package gonedice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Record 记录一次求值取得的全部随机数，可交给 Replay 重新求值以核对结果
+// 使用 WithRecord 或设置 RD.Record 后由 Result.Record 返回
+type Record struct {
+	// Algorithm 随机数来源的算法，如 "math/rand"、"pcg"、"chacha8"、"crypto/rand"，自定义来源为空
+	Algorithm string `json:"algorithm,omitempty"`
+	// Seed 随机数来源的初始种子，来源不是以固定种子创建时为空
+	// 只有当来源专为这次求值创建时（例如 Compile 中的 WithSeed），种子才能单独复现 Draws
+	Seed string `json:"seed,omitempty"`
+	// Draws 按取数顺序排列的随机数
+	Draws []Draw `json:"draws"`
+	// Value 求值的最终结果
+	Value int `json:"value"`
+}
+
+// Draw 是一次随机数取值
+type Draw struct {
+	// Faces 取值的范围，即骰子面数；b/p 的十位与个位为 10
+	Faces int `json:"faces"`
+	// Value 取得的点数，范围为 [1, Faces]
+	Value int `json:"value"`
+}
+
+// String 返回紧凑的文本形式，例如 math/rand(42) =13 20:13
+func (r *Record) String() string {
+	var sb strings.Builder
+	if r.Algorithm != "" {
+		sb.WriteString(r.Algorithm)
+		if r.Seed != "" {
+			fmt.Fprintf(&sb, "(%s)", r.Seed)
+		}
+		sb.WriteByte(' ')
+	}
+	sb.WriteString("=" + strconv.Itoa(r.Value))
+	for _, d := range r.Draws {
+		fmt.Fprintf(&sb, " %d:%d", d.Faces, d.Value)
+	}
+	return sb.String()
+}
+
+// describer 由能够报告算法与种子的内置随机数来源实现
+type describer interface {
+	describe() (algorithm, seed string)
+}
+
+// newRecord 为随机数来源 src 创建空的记录
+func newRecord(src Source) *Record {
+	rec := &Record{}
+	if d, ok := src.(describer); ok {
+		rec.Algorithm, rec.Seed = d.describe()
+	}
+	return rec
+}
+
+// Replay 使用 rec 中记录的随机数重新求值 expr，用于核对有争议的掷骰
+// opts 应与原始求值一致（变量表、默认面数等），其中的随机数来源会被忽略
+// 记录的随机数与表达式的取数不一致、有剩余或最终结果不同时返回 ErrReplayMismatch
+func Replay(expr string, rec *Record, opts ...Option) (Result, error) {
+	p, err := Compile(expr, opts...)
+	if err != nil {
+		e := err.(*Error)
+		return Result{Error: e.Code, Err: e}, err
+	}
+	src := &replaySource{draws: rec.Draws, bad: -1}
+	res := p.Roll(WithRNG(src))
+	mismatch := func(format string, args ...any) (Result, error) {
+		return res, newError(ErrReplayMismatch, expr, 0, len(expr), "", format, args...).localize(newConfig(opts).locale)
+	}
+	switch {
+	case src.bad >= 0:
+		return mismatch("draw %d does not match the expression", src.bad+1)
+	case src.next != len(rec.Draws):
+		return mismatch("%d of %d draws were not used", len(rec.Draws)-src.next, len(rec.Draws))
+	case res.Error != "":
+		return res, res.Err
+	case res.Value != rec.Value:
+		return mismatch("replayed value %d differs from recorded %d", res.Value, rec.Value)
+	}
+	return res, nil
+}
+
+// replaySource 依次返回记录中的随机数
+// 取数的范围与记录不一致或记录用尽时记下第一个出错的位置，之后总是返回 0
+type replaySource struct {
+	draws []Draw
+	next  int
+	bad   int
+}
+
+// Intn 实现 Source
+func (s *replaySource) Intn(n int) int {
+	if s.bad >= 0 {
+		return 0
+	}
+	if s.next >= len(s.draws) || s.draws[s.next].Faces != n || s.draws[s.next].Value < 1 || s.draws[s.next].Value > n {
+		s.bad = s.next
+		return 0
+	}
+	s.next++
+	return s.draws[s.next-1].Value - 1
+}
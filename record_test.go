@@ -0,0 +1,74 @@
+package gonedice
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	res := MustCompile("4d6kh3+1d20", WithSeed(5), WithRecord()).Roll()
+	rec := res.Record
+	if rec == nil || rec.Algorithm != "math/rand" || rec.Seed != "5" || rec.Value != res.Value {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if len(rec.Draws) != 5 || rec.Draws[0].Faces != 6 || rec.Draws[4].Faces != 20 {
+		t.Fatalf("expected four d6 and one d20 draws: %v", rec.Draws)
+	}
+	replayed, err := Replay("4d6kh3+1d20", rec)
+	if err != nil || replayed.Value != res.Value {
+		t.Fatalf("replay should reproduce %d: %d %v", res.Value, replayed.Value, err)
+	}
+
+	for _, expr := range []string{"1b2+1p1+4df+5a8+2c6", "\"{1d6}\"lp3", "(1d4)d6"} {
+		res := MustCompile(expr, WithRecord()).Roll()
+		if res.Error != "" || res.Record == nil {
+			t.Fatalf("%q: unexpected result %v", expr, res.Err)
+		}
+		if _, err := Replay(expr, res.Record); err != nil {
+			t.Fatalf("%q: replay failed: %v", expr, err)
+		}
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	rec := &Record{Draws: []Draw{{6, 3}, {6, 4}}, Value: 7}
+	if _, err := Replay("2d6", rec); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	cases := []struct {
+		expr string
+		rec  *Record
+	}{
+		{"2d6", &Record{Draws: []Draw{{6, 3}, {6, 4}}, Value: 8}},
+		{"2d6", &Record{Draws: []Draw{{6, 3}}, Value: 3}},
+		{"1d6", &Record{Draws: []Draw{{6, 3}, {6, 4}}, Value: 3}},
+		{"2d8", &Record{Draws: []Draw{{6, 3}, {6, 4}}, Value: 7}},
+		{"2d6", &Record{Draws: []Draw{{6, 3}, {6, 9}}, Value: 12}},
+	}
+	for _, c := range cases {
+		_, err := Replay(c.expr, c.rec)
+		var e *Error
+		if !errors.As(err, &e) || e.Code != ErrReplayMismatch {
+			t.Fatalf("%q %v: expected mismatch got %v", c.expr, c.rec.Draws, err)
+		}
+	}
+}
+
+func TestRecordDescribesSource(t *testing.T) {
+	r := New("1d20", nil, WithRecord(), WithRNG(NewPCGSource(1, 2)))
+	r.Roll()
+	rec := r.Result().Record
+	if rec.Algorithm != "pcg" || rec.Seed != "1,2" || len(rec.Draws) != 1 {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if want := fmt.Sprintf("pcg(1,2) =%d 20:%d", rec.Value, rec.Draws[0].Value); rec.String() != want {
+		t.Fatalf("unexpected text %q", rec.String())
+	}
+	if res := NewRoller(WithRecord()).Roll("1d6"); res.Record == nil || res.Record.Algorithm != "pcg" || res.Record.Seed != "" {
+		t.Fatalf("pooled sources should not report a seed: %+v", res.Record)
+	}
+	if res := MustCompile("1d6").Roll(); res.Record != nil {
+		t.Fatalf("record should be opt-in")
+	}
+}
@@ -0,0 +1,207 @@
+package gonedice
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Renderer 将掷骰结果渲染为文本
+// 求值结束时使用 WithRenderer 或 RD.Renderer 指定的渲染器填充 Result.Detail，默认为 PlainRenderer
+type Renderer interface {
+	Render(res Result) string
+}
+
+// RendererFunc 将普通函数适配为 Renderer
+type RendererFunc func(res Result) string
+
+// Render 实现 Renderer
+func (f RendererFunc) Render(res Result) string {
+	return f(res)
+}
+
+// PlainRenderer 渲染值、元数据与取值范围，如 17 [1,5!,2,5!,4] min=3 max=∞
+// 不包含临时变量与变量表，适合在公开频道中使用
+type PlainRenderer struct{}
+
+// Render 实现 Renderer
+func (PlainRenderer) Render(res Result) string {
+	if res.Err != nil {
+		return res.Err.Error()
+	}
+	return plainText(res)
+}
+
+// CompactRenderer 只渲染表达式与结果，如 4d6kh3=13
+type CompactRenderer struct{}
+
+// Render 实现 Renderer
+func (CompactRenderer) Render(res Result) string {
+	if res.Err != nil {
+		return res.Err.Error()
+	}
+	return strings.TrimSpace(res.Expr) + "=" + valueText(res)
+}
+
+// VerboseRenderer 在 PlainRenderer 的基础上附加临时变量与完整的变量表，用于调试
+// 如 14 min=5 max=10 temp:{t1=4} vt:{STR=3,T1=4}；变量表可能包含不应公开的数值
+type VerboseRenderer struct{}
+
+// Render 实现 Renderer
+func (VerboseRenderer) Render(res Result) string {
+	if res.Err != nil {
+		return res.Err.Error()
+	}
+	parts := []string{plainText(res)}
+	if len(res.temp) > 0 {
+		keys := make([]int, 0, len(res.temp))
+		for k := range res.temp {
+			keys = append(keys, k)
+		}
+		sort.Ints(keys)
+		kvs := make([]string, 0, len(keys))
+		for _, k := range keys {
+			kvs = append(kvs, fmt.Sprintf("t%d=%d", k, res.temp[k]))
+		}
+		parts = append(parts, fmt.Sprintf("%s:{%s}", res.locale.text("detail.temp", "temp"), strings.Join(kvs, ",")))
+	}
+	if len(res.vars) > 0 {
+		keys := make([]string, 0, len(res.vars))
+		for k := range res.vars {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		kvs := make([]string, 0, len(keys))
+		for _, k := range keys {
+			kvs = append(kvs, fmt.Sprintf("%s=%d", k, res.vars[k]))
+		}
+		parts = append(parts, fmt.Sprintf("%s:{%s}", res.locale.text("detail.vt", "vt"), strings.Join(kvs, ",")))
+	}
+	return strings.Join(parts, " ")
+}
+
+// MarkdownRenderer 渲染骰子机器人风格的 Markdown，如 `4d6kh3` = [6,4,3,~~1~~] = **13**
+// 被丢弃的骰子以删除线显示；没有 Trace 时中间一步只列出全部骰子
+type MarkdownRenderer struct{}
+
+// Render 实现 Renderer
+func (MarkdownRenderer) Render(res Result) string {
+	if res.Err != nil {
+		return "`" + res.Err.Error() + "`"
+	}
+	value := valueText(res)
+	mid := ""
+	if res.Trace != nil {
+		mid = res.Trace.expand()
+	} else if len(res.Dice) > 0 {
+		mid = diceList(res.Dice)
+	}
+	steps := []string{"`" + strings.TrimSpace(res.Expr) + "`"}
+	if mid != "" && mid != strings.TrimSpace(res.Expr) && mid != value {
+		steps = append(steps, mid)
+	}
+	return strings.Join(append(steps, "**"+value+"**"), " = ")
+}
+
+// NewTemplateRenderer 使用 text/template 模板创建渲染器，模板的数据为 Result
+// 模板中可以使用以下函数：
+//
+//	dice   渲染骰子列表，如 {{dice .Dice}} 输出 [6,4,3,~~1~~]
+//	plain  PlainRenderer 的输出
+//	steps  骰子机器人风格的步骤描述，如 1d20+5 = [13]+5 = 18
+//
+// 例如 "{{.Expr}} → {{.Value}}" 或 "{{steps .}}"；执行模板出错时返回错误信息
+func NewTemplateRenderer(text string) (Renderer, error) {
+	tmpl, err := template.New("gonedice").Funcs(template.FuncMap{
+		"dice":  diceList,
+		"plain": PlainRenderer{}.Render,
+		"steps": stepsText,
+	}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return RendererFunc(func(res Result) string {
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, res); err != nil {
+			return err.Error()
+		}
+		return sb.String()
+	}), nil
+}
+
+// plainText 渲染值、元数据列表以及非固定值的取值范围
+func plainText(res Result) string {
+	parts := []string{strconv.Itoa(res.Value)}
+	if res.MetaTuple != nil {
+		parts = append(parts, metaText(res))
+	}
+	if res.Value != 0 {
+		if res.Min != res.Max || res.MinOpen || res.MaxOpen {
+			parts = append(parts, boundText(res.locale.text("detail.min", "min"), res.Min, res.MinOpen, "-∞"))
+			parts = append(parts, boundText(res.locale.text("detail.max", "max"), res.Max, res.MaxOpen, "∞"))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// metaText 渲染元数据列表：字符串加引号，与骰子对应的元素使用骰子的标注（如 6!、1→4）
+func metaText(res Result) string {
+	items := make([]string, len(res.MetaTuple))
+	ints := make([]int, 0, len(res.MetaTuple))
+	for i, m := range res.MetaTuple {
+		switch m := m.(type) {
+		case string:
+			items[i] = fmt.Sprintf("\"%s\"", m)
+		case int:
+			items[i] = strconv.Itoa(m)
+			ints = append(ints, m)
+		}
+	}
+	if len(ints) == len(items) {
+		if kept := keptDice(res.Dice, ints); kept != nil {
+			for i, di := range kept {
+				items[i] = res.Dice[di].text()
+			}
+		}
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+// valueText 渲染结果的值，多元组字面量与字符串多元组渲染为元素列表
+// 多元组字面量的渲染与 Trace 相同，如 [1d6,2d6] 渲染为 [5,2]
+func valueText(res Result) string {
+	if res.tuple {
+		return tupleText(res.MetaTuple)
+	}
+	if len(res.MetaTuple) > 0 {
+		if _, ok := res.MetaTuple[0].(string); ok {
+			return metaText(res)
+		}
+	}
+	return strconv.Itoa(res.Value)
+}
+
+// stepsText 渲染骰子机器人风格的步骤描述，没有 Trace 时只列出全部骰子
+func stepsText(res Result) string {
+	if res.Err != nil {
+		return res.Err.Error()
+	}
+	if res.Trace != nil {
+		return res.Trace.String()
+	}
+	steps := []string{strings.TrimSpace(res.Expr)}
+	if len(res.Dice) > 0 {
+		steps = append(steps, diceList(res.Dice))
+	}
+	return strings.Join(append(steps, valueText(res)), " = ")
+}
+
+// boundText 渲染 min=/max= 片段，无界时使用 inf 表示
+func boundText(name string, v int, open bool, inf string) string {
+	if open {
+		return name + "=" + inf
+	}
+	return fmt.Sprintf("%s=%d", name, v)
+}
@@ -0,0 +1,77 @@
+package gonedice
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderers(t *testing.T) {
+	vt := map[string]int{"STR": 3, "SAN": 45}
+	roll := func(expr string, r Renderer, faces ...int) Result {
+		res := MustCompile(expr, WithRNG(&fixedSource{faces: faces}), WithValueTable(vt), WithRenderer(r)).Roll()
+		if res.Error != "" {
+			t.Fatalf("%q: unexpected error %v", expr, res.Err)
+		}
+		return res
+	}
+	cases := []struct {
+		expr     string
+		renderer Renderer
+		faces    []int
+		want     string
+	}{
+		{"4d6kh3+{STR}", PlainRenderer{}, []int{6, 4, 3, 1}, "16 min=6 max=21"},
+		{"4d6kh3", PlainRenderer{}, []int{6, 4, 3, 1}, "13 [6,4,3] min=3 max=18"},
+		{"4d6kh3", CompactRenderer{}, []int{6, 4, 3, 1}, "4d6kh3=13"},
+		{"4d6kh3", MarkdownRenderer{}, []int{6, 4, 3, 1}, "`4d6kh3` = [6,4,3,~~1~~] = **13**"},
+		{"1d20+{STR}", MarkdownRenderer{}, []int{13}, "`1d20+{STR}` = [13]+3 = **16**"},
+		{"[1d6,2d6]", CompactRenderer{}, []int{5, 1, 1}, "[1d6,2d6]=[5,2]"},
+		{"[1d6,2d6]", MarkdownRenderer{}, []int{5, 1, 1}, "`[1d6,2d6]` = [[5],[1,1]] = **[5,2]**"},
+		{"($t1=(1d6))+{STR}", VerboseRenderer{}, []int{4}, "7 min=4 max=9 temp:{t1=4} vt:{SAN=45,STR=3,T1=4}"},
+	}
+	for _, c := range cases {
+		if got := roll(c.expr, c.renderer, c.faces...).Detail; got != c.want {
+			t.Fatalf("%q %T: expected %q got %q", c.expr, c.renderer, c.want, got)
+		}
+	}
+
+	// the default renderer must not leak the value table
+	res := MustCompile("1d20+{SAN}", WithValueTable(vt)).Roll()
+	if strings.Contains(res.Detail, "vt:") || strings.Contains(res.Detail, "STR") {
+		t.Fatalf("default detail leaked variables: %q", res.Detail)
+	}
+
+	// renderers can also be applied after the fact
+	if got := (MarkdownRenderer{}).Render(res); !strings.HasPrefix(got, "`1d20+{SAN}` = [") {
+		t.Fatalf("unexpected markdown %q", got)
+	}
+	if got := (CompactRenderer{}).Render(MustCompile("1/0").Roll()); !strings.Contains(got, "NODE_RIGHT_VAL_INVALID") {
+		t.Fatalf("errors should be rendered: %q", got)
+	}
+
+	// tuple values agree with Trace.String
+	res = MustCompile("[1d6,2d6]", WithTrace(), WithRNG(&fixedSource{faces: []int{5, 1, 1}})).Roll()
+	if got := (CompactRenderer{}).Render(res); !strings.HasSuffix(res.Trace.String(), strings.TrimPrefix(got, "[1d6,2d6]=")) {
+		t.Fatalf("tuple rendering should match the trace: %q %q", got, res.Trace.String())
+	}
+}
+
+func TestTemplateRenderer(t *testing.T) {
+	r, err := NewTemplateRenderer("{{.Expr}} → {{.Value}} {{dice .Dice}} | {{steps .}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := MustCompile("2d6kl1+1", WithRNG(&fixedSource{faces: []int{5, 2}}), WithRenderer(r)).Roll()
+	if want := "2d6kl1+1 → 3 [~~5~~,2] | 2d6kl1+1 = [~~5~~,2]+1 = 3"; res.Detail != want {
+		t.Fatalf("expected %q got %q", want, res.Detail)
+	}
+	if _, err := NewTemplateRenderer("{{.Value"); err == nil {
+		t.Fatalf("invalid template should fail to parse")
+	}
+	rd := New("1d6", nil)
+	rd.Renderer = RendererFunc(func(res Result) string { return "custom" })
+	rd.Roll()
+	if rd.Result().Detail != "custom" {
+		t.Fatalf("RD.Renderer not used: %q", rd.Result().Detail)
+	}
+}
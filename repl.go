@@ -20,8 +20,17 @@ import (
 //   - Value: 计算结果的数值
 //   - Meta: 详细的掷骰过程信息
 //   - Detail: 格式化的结果详情
-func RunREPL() {
-	fmt.Println("gonedice REPL - 输入 OneDice 表达式或 'quit' 退出")
+//
+// opts 会传给每次求值；没有给出 WithLocale 时按 LC_ALL、LC_MESSAGES、LANG 环境变量选择消息目录，
+// 都没有匹配时使用默认文本
+func RunREPL(opts ...Option) {
+	cfg := newConfig(opts)
+	if cfg.locale == nil {
+		cfg.locale = envLocale()
+		opts = append([]Option{func(c *config) { c.locale = cfg.locale }}, opts...)
+	}
+	loc := cfg.locale
+	fmt.Println(loc.text("repl.banner", ""))
 
 	// 历史记录数组
 	var history []string
@@ -47,23 +56,33 @@ func RunREPL() {
 			}
 		}
 
-		r := New(line, nil)
+		r := New(line, nil, opts...)
 		r.Roll()
 		res := r.Result()
-		if res.Error != "" {
-			fmt.Println("Error:", res.Error)
+		if res.Err != nil {
+			fmt.Printf("%s:\n%+v\n", loc.text("repl.error", ""), res.Err)
 			continue
 		}
-		fmt.Printf("Value: %d\n", res.Value)
-		fmt.Printf("Meta: %v\n", res.MetaTuple)
-		fmt.Printf("Detail: %s\n", res.Detail)
+		fmt.Printf("%s: %d\n", loc.text("repl.value", ""), res.Value)
+		fmt.Printf("%s: %v\n", loc.text("repl.meta", ""), res.MetaTuple)
+		fmt.Printf("%s: %s\n", loc.text("repl.detail", ""), res.Detail)
 	}
 
 	// 简单提示如何查看历史
 	if len(history) > 0 {
-		fmt.Println("\n本次会话的输入历史:")
+		fmt.Println("\n" + loc.text("repl.history", ""))
 		for i, cmd := range history {
 			fmt.Printf("%3d: %s\n", i+1, cmd)
 		}
 	}
 }
+
+// envLocale 按 LC_ALL、LC_MESSAGES、LANG 的顺序查找第一个已注册的消息目录
+func envLocale() *Locale {
+	for _, key := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if loc, ok := LookupLocale(os.Getenv(key)); ok {
+			return loc
+		}
+	}
+	return nil
+}
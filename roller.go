@@ -0,0 +1,84 @@
+package gonedice
+
+import (
+	"context"
+	randv2 "math/rand/v2"
+	"sync"
+)
+
+// Roller 是可以被多个 goroutine 同时使用的掷骰器
+// 与 RD 不同，Roller 只保存配置：每次调用使用独立的求值状态与临时变量表，
+// 变量表在每次调用时复制，赋值运算不会修改调用者或其他调用可见的表
+type Roller struct {
+	// cfg NewRoller 给出的默认配置，创建后不再修改
+	cfg config
+	// opts NewRoller 给出的选项，编译表达式时使用其中的资源限制
+	opts []Option
+	// shared 用户通过 WithRNG 或 WithSeed 指定的随机数来源，使用互斥锁保护
+	shared Source
+	// pool 未指定随机数来源时使用的生成器池，每个生成器同一时间只被一次调用使用
+	pool sync.Pool
+}
+
+// NewRoller 创建一个并发安全的掷骰器，opts 作为每次调用的默认选项
+// 指定了随机数来源时所有调用共享该来源并依次取数；否则每次调用从池中取出一个独立播种的 PCG 生成器
+func NewRoller(opts ...Option) *Roller {
+	r := &Roller{cfg: newConfig(opts), opts: opts}
+	if r.cfg.rng != nil {
+		r.shared = &lockedSource{src: r.cfg.rng}
+	}
+	r.pool.New = func() any {
+		// 池中的生成器会被多次调用复用，种子无法复现单次调用的取数，因此不记录种子
+		return v2Source{r: randv2.New(randv2.NewPCG(randv2.Uint64(), randv2.Uint64())), alg: "pcg"}
+	}
+	return r
+}
+
+// Roll 解析并求值表达式，opts 覆盖 NewRoller 给出的默认选项
+func (r *Roller) Roll(expr string, opts ...Option) Result {
+	return r.RollContext(context.Background(), expr, opts...)
+}
+
+// RollContext 与 Roll 相同，但 ctx 被取消或超时后求值会尽快中止并返回 ErrCancelled
+func (r *Roller) RollContext(ctx context.Context, expr string, opts ...Option) Result {
+	cfg := r.config(nil, opts)
+	root, err := parse(expr, cfg.limits.resolve(), cfg.normalize)
+	if err != nil {
+		return Result{Expr: expr, Error: err.Code, Err: err.localize(cfg.locale)}
+	}
+	return r.run(ctx, cfg, &Program{expr: expr, root: root})
+}
+
+// RollProgram 使用 Roller 的配置对编译后的表达式求值
+// 选项依次按 NewRoller、Compile 与 opts 的顺序应用，适合对缓存的宏反复求值
+func (r *Roller) RollProgram(ctx context.Context, p *Program, opts ...Option) Result {
+	return r.run(ctx, r.config(p.opts, opts), p)
+}
+
+// config 在默认配置上依次应用编译时与调用时的选项
+// 调用时的选项未指定随机数来源时 rng 为 nil，由 run 决定使用共享来源还是池中的生成器
+func (r *Roller) config(progOpts, opts []Option) config {
+	cfg := r.cfg
+	cfg.rng = nil
+	for _, opt := range progOpts {
+		opt(&cfg)
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// run 为一次调用准备随机数来源并求值
+func (r *Roller) run(ctx context.Context, cfg config, p *Program) Result {
+	switch {
+	case cfg.rng != nil:
+	case r.shared != nil:
+		cfg.rng = r.shared
+	default:
+		src := r.pool.Get().(Source)
+		defer r.pool.Put(src)
+		cfg.rng = src
+	}
+	return newEvaluator(ctx, cfg, p.expr).run(p.root)
+}
@@ -0,0 +1,72 @@
+package gonedice
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestRollerConcurrent(t *testing.T) {
+	vt := map[string]int{"STR": 3}
+	r := NewRoller(WithValueTable(vt))
+	p := MustCompile("($t1=(1d6))+$t1+{STR}")
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				res := r.Roll("($t1=(1d6))+$t1+{STR}")
+				if res.Error != "" || res.Value < 5 || res.Value > 15 || (res.Value-3)%2 != 0 {
+					t.Errorf("unexpected result %d %v", res.Value, res.Err)
+					return
+				}
+				res = r.RollProgram(context.Background(), p)
+				if res.Error != "" || (res.Value-3)%2 != 0 {
+					t.Errorf("unexpected program result %d %v", res.Value, res.Err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(vt) != 1 {
+		t.Fatalf("roller should not write to the shared value table: %v", vt)
+	}
+}
+
+func TestRollerIsolatesCalls(t *testing.T) {
+	r := NewRoller(WithStrictVariables())
+	if res := r.Roll("$t1=3"); res.Error != "" {
+		t.Fatalf("unexpected error %v", res.Err)
+	}
+	if res := r.Roll("$t1"); res.Error != ErrInputChildParaInvalid {
+		t.Fatalf("temp variable leaked into the next call: %v", res.Value)
+	}
+	if res := r.Roll("1d"); res.Error != "" || res.Value < 1 || res.Value > 100 {
+		t.Fatalf("default faces expected: %d %v", res.Value, res.Err)
+	}
+	if res := r.Roll("1d", WithDefaultFaces(1)); res.Value != 1 {
+		t.Fatalf("per-call option should override defaults: %d", res.Value)
+	}
+	if res := r.Roll("1d6", WithLimits(Limits{MaxExprLen: 2})); res.Error != ErrLimitExceeded {
+		t.Fatalf("per-call limits should apply when parsing: %v", res.Error)
+	}
+}
+
+func TestRollerSharedSource(t *testing.T) {
+	r := NewRoller(WithSeed(7))
+	var got []any
+	for i := 0; i < 3; i++ {
+		got = append(got, r.Roll("3d6").MetaTuple...)
+	}
+	want := MustCompile("9d6", WithSeed(7)).Roll().MetaTuple
+	if len(got) != len(want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("shared source should be drawn in sequence: expected %v got %v", want, got)
+		}
+	}
+}
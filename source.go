@@ -0,0 +1,108 @@
+package gonedice
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"math/rand"
+	randv2 "math/rand/v2"
+	"strconv"
+	"sync"
+)
+
+// Source 是掷骰使用的随机数来源
+// *math/rand.Rand 直接满足该接口；除 NewCryptoSource 外，内置实现都不能被多个 goroutine 同时使用
+type Source interface {
+	// Intn 返回 [0, n) 内均匀分布的随机整数，n 总是大于 0
+	Intn(n int) int
+}
+
+// NewMathSource 返回以 seed 为种子的 math/rand 随机数来源，相同的种子产生相同的掷骰序列
+func NewMathSource(seed int64) Source {
+	return mathSource{rand.New(rand.NewSource(seed)), seed}
+}
+
+// NewCryptoSource 返回基于 crypto/rand 的随机数来源，结果不可预测，适合公开场合的掷骰
+// 读取系统随机数失败时 panic
+func NewCryptoSource() Source {
+	return cryptoSource{}
+}
+
+// NewChaCha8Source 返回以 seed 为种子的 ChaCha8 随机数来源
+// 序列由种子完全确定，且在不同平台与 Go 版本之间保持一致
+func NewChaCha8Source(seed [32]byte) Source {
+	return v2Source{r: randv2.New(randv2.NewChaCha8(seed)), alg: "chacha8", seed: hex.EncodeToString(seed[:])}
+}
+
+// NewPCGSource 返回以 (seed1, seed2) 为种子的 PCG 随机数来源，速度快且序列可复现
+func NewPCGSource(seed1, seed2 uint64) Source {
+	return v2Source{r: randv2.New(randv2.NewPCG(seed1, seed2)), alg: "pcg", seed: fmt.Sprintf("%d,%d", seed1, seed2)}
+}
+
+// mathSource 是带有种子信息的 math/rand 生成器
+type mathSource struct {
+	*rand.Rand
+	seed int64
+}
+
+// describe 实现 describer
+func (s mathSource) describe() (string, string) {
+	return "math/rand", strconv.FormatInt(s.seed, 10)
+}
+
+// cryptoSource 使用 crypto/rand 生成随机整数
+type cryptoSource struct{}
+
+// Intn 实现 Source，crypto/rand.Int 内部使用拒绝采样，结果没有取模偏差
+func (cryptoSource) Intn(n int) int {
+	v, err := crand.Int(crand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic("gonedice: crypto/rand: " + err.Error())
+	}
+	return int(v.Int64())
+}
+
+// describe 实现 describer，crypto/rand 没有种子
+func (cryptoSource) describe() (string, string) {
+	return "crypto/rand", ""
+}
+
+// v2Source 将 math/rand/v2 的生成器适配为 Source
+type v2Source struct {
+	r *randv2.Rand
+	// alg 与 seed 是生成器的算法与初始种子，用于填充 Record
+	alg  string
+	seed string
+}
+
+// Intn 实现 Source
+func (s v2Source) Intn(n int) int {
+	return s.r.IntN(n)
+}
+
+// describe 实现 describer
+func (s v2Source) describe() (string, string) {
+	return s.alg, s.seed
+}
+
+// lockedSource 用互斥锁保护不能被多个 goroutine 同时使用的随机数来源
+type lockedSource struct {
+	mu  sync.Mutex
+	src Source
+}
+
+// Intn 实现 Source
+func (s *lockedSource) Intn(n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Intn(n)
+}
+
+// describe 实现 describer，报告被保护的来源的算法与种子
+func (s *lockedSource) describe() (string, string) {
+	if d, ok := s.src.(describer); ok {
+		return d.describe()
+	}
+	return "", ""
+}
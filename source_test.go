@@ -0,0 +1,48 @@
+package gonedice
+
+import "testing"
+
+func TestSeededSourcesAreDeterministic(t *testing.T) {
+	sources := map[string]func() Source{
+		"math":    func() Source { return NewMathSource(7) },
+		"chacha8": func() Source { return NewChaCha8Source([32]byte{1, 2, 3}) },
+		"pcg":     func() Source { return NewPCGSource(1, 2) },
+	}
+	p := MustCompile("10d6+1d20")
+	for name, mk := range sources {
+		a := p.Roll(WithRNG(mk()))
+		b := p.Roll(WithRNG(mk()))
+		if a.Error != "" || a.Detail != b.Detail {
+			t.Fatalf("%s: same seed gave %q and %q", name, a.Detail, b.Detail)
+		}
+	}
+
+	r := New("10d6", nil)
+	r.SetRNG(NewPCGSource(3, 4))
+	r.Roll()
+	want := p.Roll(WithRNG(NewPCGSource(3, 4))).Dice
+	for i, d := range r.Result().Dice {
+		if d.Value != want[i].Value {
+			t.Fatalf("SetRNG and WithRNG should draw the same dice: %+v vs %+v", r.Result().Dice, want)
+		}
+	}
+}
+
+func TestCryptoSource(t *testing.T) {
+	src := NewCryptoSource()
+	seen := map[int]bool{}
+	for i := 0; i < 600; i++ {
+		v := src.Intn(6)
+		if v < 0 || v >= 6 {
+			t.Fatalf("Intn(6) returned %d", v)
+		}
+		seen[v] = true
+	}
+	if len(seen) != 6 {
+		t.Fatalf("600 draws should cover every face, got %v", seen)
+	}
+	res := MustCompile("4d6kh3").Roll(WithRNG(src))
+	if res.Error != "" || res.Value < 3 || res.Value > 18 {
+		t.Fatalf("unexpected result %q", res.Detail)
+	}
+}
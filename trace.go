@@ -0,0 +1,150 @@
+package gonedice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Trace 是求值过程的步骤树，每个节点对应表达式中的一个子表达式
+// 使用 WithTrace 或设置 RD.Trace 后由 Result.Trace 返回
+type Trace struct {
+	// Op 运算符，如 "d"、"+"、"kh"、"a"；括号为 "()"，三元运算为 "?:"，
+	// 字面量与引用为 "number"、"string"、"tuple"、"temp"、"var"
+	Op string `json:"op"`
+	// Expr 子表达式在原始表达式中的文本
+	Expr string `json:"expr"`
+	// Value 子表达式的值
+	Value int `json:"value"`
+	// MetaTuple 子表达式的元数据列表，与 Result.MetaTuple 相同
+	MetaTuple []interface{} `json:"metaTuple,omitempty"`
+	// Dice 参与该子表达式结果的骰子，包括来自输入的骰子，被丢弃的骰子标记 Dropped
+	Dice []Die `json:"dice,omitempty"`
+	// Inputs 按求值顺序排列的输入；短路运算与三元运算只包含实际求值的部分
+	Inputs []*Trace `json:"inputs,omitempty"`
+}
+
+// String 返回骰子机器人风格的步骤描述，依次为表达式、代入骰子后的算式与结果，例如：
+//
+//	1d20+5 = [13]+5 = 18
+//	4d6kh3 = [6,4,3,~~1~~] = 13
+//
+// 被丢弃的骰子以 ~~ 包围；中间一步与表达式或结果相同时省略
+func (t *Trace) String() string {
+	value := t.valueText()
+	steps := []string{t.Expr}
+	if mid := t.expand(); mid != t.Expr && mid != value {
+		steps = append(steps, mid)
+	}
+	return strings.Join(append(steps, value), " = ")
+}
+
+// expand 渲染代入骰子后的算式：标量运算与括号展开其输入，
+// 其余带骰子的节点渲染为骰子列表，不带骰子的节点渲染为字面量或值
+func (t *Trace) expand() string {
+	switch {
+	case t.Op == "()" && len(t.Inputs) == 1:
+		return "(" + t.Inputs[0].expand() + ")"
+	case t.Op == "=" && len(t.Inputs) > 0:
+		// 赋值目标不求值，从表达式文本中取出
+		right := t.Inputs[len(t.Inputs)-1]
+		target := strings.TrimSpace(strings.TrimSuffix(t.Expr, right.Expr))
+		return target + right.expand()
+	case t.Op == "?:" && len(t.Inputs) == 2:
+		// 条件与实际求值的分支，未选中的分支没有输入
+		return t.Inputs[0].expand() + "?" + t.Inputs[1].expand()
+	case t.Op == "tuple" && len(t.Inputs) > 0:
+		// 字符串多元组的元素不求值，没有输入，渲染为值
+		items := make([]string, len(t.Inputs))
+		for i, in := range t.Inputs {
+			items[i] = in.expand()
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	case isScalarOp(t.Op) && len(t.Inputs) == 2:
+		return t.Inputs[0].expand() + t.Op + t.Inputs[1].expand()
+	case (t.Op == "-" || t.Op == "+" || t.Op == "!") && len(t.Inputs) == 1:
+		return t.Op + t.Inputs[0].expand()
+	case len(t.Dice) > 0:
+		return diceList(t.Dice)
+	case t.Op == "number":
+		return t.Expr
+	}
+	return t.valueText()
+}
+
+// valueText 渲染节点的值，多元组字面量渲染为元素列表
+func (t *Trace) valueText() string {
+	if t.Op == "tuple" {
+		return tupleText(t.MetaTuple)
+	}
+	return strconv.Itoa(t.Value)
+}
+
+// tupleText 渲染多元组字面量的元素列表，如 [5,2]、[a,b]
+func tupleText(meta []interface{}) string {
+	items := make([]string, len(meta))
+	for i, m := range meta {
+		items[i] = fmt.Sprint(m)
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+// diceList 渲染骰子列表，如 [6,4,3,~~1~~]
+func diceList(dice []Die) string {
+	items := make([]string, len(dice))
+	for i, d := range dice {
+		items[i] = d.text()
+		if d.Dropped {
+			items[i] = "~~" + items[i] + "~~"
+		}
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+// nodeOp 返回语法树节点在 Trace 中的运算符
+func nodeOp(n node) string {
+	switch n := n.(type) {
+	case *numberNode:
+		return "number"
+	case *stringNode:
+		return "string"
+	case *tupleNode:
+		return "tuple"
+	case *tempNode:
+		return "temp"
+	case *varNode:
+		return "var"
+	case *groupNode:
+		return "()"
+	case *ternaryNode:
+		return "?:"
+	case *diceNode:
+		return "d"
+	case *chainNode:
+		return n.op
+	case *unaryNode:
+		return n.op
+	case *binaryNode:
+		return n.op
+	}
+	return ""
+}
+
+// metaTuple 将元数据转换为 Result.MetaTuple 的形式，没有元数据时返回 nil
+func metaTuple(val Value) []interface{} {
+	if !val.MetaEnable {
+		return nil
+	}
+	if len(val.MetaStr) > 0 {
+		meta := make([]interface{}, len(val.MetaStr))
+		for i, vv := range val.MetaStr {
+			meta[i] = vv
+		}
+		return meta
+	}
+	meta := make([]interface{}, len(val.Meta))
+	for i, vv := range val.Meta {
+		meta[i] = vv
+	}
+	return meta
+}
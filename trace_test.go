@@ -0,0 +1,71 @@
+package gonedice
+
+import "testing"
+
+// fixedSource returns the given faces (1-based) in order, cycling when exhausted
+type fixedSource struct {
+	faces []int
+	next  int
+}
+
+func (s *fixedSource) Intn(n int) int {
+	v := s.faces[s.next%len(s.faces)] - 1
+	s.next++
+	return v
+}
+
+func TestTraceString(t *testing.T) {
+	cases := []struct {
+		expr  string
+		faces []int
+		want  string
+	}{
+		{"1d20+5", []int{13}, "1d20+5 = [13]+5 = 18"},
+		{"4d6kh3", []int{6, 4, 3, 1}, "4d6kh3 = [6,4,3,~~1~~] = 13"},
+		{"1d20 + {STR}", []int{7}, "1d20 + {STR} = [7]+3 = 10"},
+		{"(2d6+1)*2", []int{2, 5}, "(2d6+1)*2 = ([2,5]+1)*2 = 16"},
+		{"3d6!", []int{6, 2, 3, 4}, "3d6! = [6!,2,3,4] = 15"},
+		{"$t1=(1d6)", []int{4}, "$t1=(1d6) = $t1=([4]) = 4"},
+		{"1+2", nil, "1+2 = 3"},
+		{"[1d6,2]", []int{5}, "[1d6,2] = [[5],2] = [5,2]"},
+		{"1d20+5>=15?2d6:0", []int{13, 1, 2}, "1d20+5>=15?2d6:0 = [13]+5>=15?[1,2] = 3"},
+		{"1d20+5>=15?2d6:0", []int{3}, "1d20+5>=15?2d6:0 = [3]+5>=15?0 = 0"},
+		{`["a","b"]`, nil, `["a","b"] = [a,b]`},
+	}
+	for _, c := range cases {
+		src := &fixedSource{faces: append(c.faces, 1)}
+		res := MustCompile(c.expr, WithTrace(), WithRNG(src), WithValueTable(map[string]int{"STR": 3})).Roll()
+		if res.Error != "" || res.Trace == nil {
+			t.Fatalf("%q: unexpected error %v", c.expr, res.Err)
+		}
+		if got := res.Trace.String(); got != c.want {
+			t.Fatalf("%q: expected %q got %q", c.expr, c.want, got)
+		}
+	}
+}
+
+func TestTraceTree(t *testing.T) {
+	r := New("2d6kh1+1d4", nil, WithTrace(), WithRNG(&fixedSource{faces: []int{2, 5, 3}}))
+	r.Roll()
+	root := r.Result().Trace
+	if root.Op != "+" || root.Value != 8 || len(root.Inputs) != 2 || len(root.Dice) != 3 {
+		t.Fatalf("unexpected root %+v", root)
+	}
+	kh := root.Inputs[0]
+	if kh.Op != "kh" || kh.Expr != "2d6kh1" || kh.Value != 5 || !kh.Dice[0].Dropped {
+		t.Fatalf("unexpected kh node %+v", kh)
+	}
+	d := kh.Inputs[0]
+	if d.Op != "d" || len(d.Inputs) != 2 || d.Inputs[0].Op != "number" || len(d.MetaTuple) != 2 {
+		t.Fatalf("unexpected d node %+v", d)
+	}
+
+	// short circuit only records the evaluated side
+	res := MustCompile("0&&1d6", WithTrace()).Roll()
+	if len(res.Trace.Inputs) != 1 {
+		t.Fatalf("short circuit should skip the right side: %+v", res.Trace.Inputs)
+	}
+	if res := MustCompile("1d6").Roll(); res.Trace != nil {
+		t.Fatalf("trace should be opt-in")
+	}
+}